@@ -0,0 +1,287 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package toolman
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"toolman.org/base/log/v2"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd for socket
+// activation (i.e. SD_LISTEN_FDS_START).
+const listenFdsStart = 3
+
+// ErrNoSocket is returned (possibly wrapped) by Listener and PacketConn when
+// no socket with the requested name was passed to the program.
+var ErrNoSocket = errors.New("no such activated socket")
+
+type activatedSocket struct {
+	name string
+	file *os.File
+	ln   net.Listener
+	pc   net.PacketConn
+}
+
+// envListenPPID is used in place of LISTEN_PID when sockets are passed from
+// a parent to a child process during a graceful restart (see Restart).
+const envListenPPID = "TOOLMAN_LISTEN_PPID"
+
+// setupActivation registers a ShutdownFunc with a to close its sockets and,
+// for the default App, collects any sockets passed to the current process
+// according to systemd's socket activation protocol (i.e. LISTEN_PID,
+// LISTEN_FDS and LISTEN_FDNAMES). These environment variables are then unset
+// so they will not be inherited by child processes.
+//
+// Sockets handed off by a parent process during a graceful restart are
+// passed in the same manner except that, since the child's PID cannot be
+// known in advance, LISTEN_PID is replaced by TOOLMAN_LISTEN_PPID which
+// must match the PID of our parent process.
+func (a *App) setupActivation() {
+	a.registerShutdown("toolman.closeActivatedSockets", func(context.Context) error {
+		a.closeSockets()
+		return nil
+	}, []ShutdownOption{Phase(StopAccepting)})
+
+	if !a.isStd() {
+		return
+	}
+
+	pid := os.Getenv("LISTEN_PID")
+	ppid := os.Getenv(envListenPPID)
+	nfds := os.Getenv("LISTEN_FDS")
+	names := os.Getenv("LISTEN_FDNAMES")
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv(envListenPPID)
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	switch {
+	case pid != "" && pid == strconv.Itoa(os.Getpid()):
+	case pid == "" && ppid != "" && ppid == strconv.Itoa(os.Getppid()):
+	default:
+		return
+	}
+
+	n, err := strconv.Atoi(nfds)
+	if err != nil || n <= 0 {
+		return
+	}
+
+	var nameList []string
+	if names != "" {
+		nameList = strings.Split(names, ":")
+	}
+
+	a.sockmutex.Lock()
+	defer a.sockmutex.Unlock()
+
+	for i := 0; i < n; i++ {
+		fd := listenFdsStart + i
+		closeOnExec(fd)
+
+		name := "unknown"
+		if i < len(nameList) && nameList[i] != "" {
+			name = nameList[i]
+		}
+
+		log.V(1).Infof("found activated socket %q on fd %d", name, fd)
+
+		a.sockets = append(a.sockets, &activatedSocket{
+			name: name,
+			file: os.NewFile(uintptr(fd), name),
+		})
+	}
+}
+
+// Listener returns a net.Listener for the socket-activated socket with the
+// given name (as specified by systemd's FileDescriptorName= setting, or
+// "unknown" if unnamed). If more than one socket shares the same name, the
+// first is returned. Repeated calls for the same name return the same
+// Listener. An error wrapping ErrNoSocket is returned if no such socket was
+// passed to the program.
+//
+// All activated sockets are closed automatically during shutdown.
+func Listener(name string) (net.Listener, error) {
+	return std.Listener(name)
+}
+
+// Listener returns a net.Listener for the socket with the given name that
+// was activated for (or registered with) a; see the package-level Listener
+// for details.
+func (a *App) Listener(name string) (net.Listener, error) {
+	a.sockmutex.Lock()
+	defer a.sockmutex.Unlock()
+
+	as, err := a.findSocket(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if as.ln == nil {
+		if as.ln, err = net.FileListener(as.file); err != nil {
+			return nil, fmt.Errorf("activated socket %q: %v", name, err)
+		}
+	}
+
+	return as.ln, nil
+}
+
+// PacketConn is similar to Listener except that it returns a net.PacketConn
+// for a socket-activated datagram socket.
+func PacketConn(name string) (net.PacketConn, error) {
+	return std.PacketConn(name)
+}
+
+// PacketConn returns a net.PacketConn for the datagram socket with the given
+// name that was activated for a; see the package-level PacketConn for
+// details.
+func (a *App) PacketConn(name string) (net.PacketConn, error) {
+	a.sockmutex.Lock()
+	defer a.sockmutex.Unlock()
+
+	as, err := a.findSocket(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if as.pc == nil {
+		if as.pc, err = net.FilePacketConn(as.file); err != nil {
+			return nil, fmt.Errorf("activated socket %q: %v", name, err)
+		}
+	}
+
+	return as.pc, nil
+}
+
+// Listen returns the socket-activated (or inherited) Listener with the given
+// name if one exists; otherwise, it calls net.Listen with network and address
+// and registers the resulting Listener under name using RegisterListener.
+// Programs supporting GracefulRestart should create their listeners using
+// Listen so that they are handed off to the new process on restart.
+func Listen(name, network, address string) (net.Listener, error) {
+	return std.Listen(name, network, address)
+}
+
+// Listen returns a's Listener with the given name, creating and registering
+// a new one if necessary; see the package-level Listen for details.
+func (a *App) Listen(name, network, address string) (net.Listener, error) {
+	ln, err := a.Listener(name)
+	if err == nil || !errors.Is(err, ErrNoSocket) {
+		return ln, err
+	}
+
+	if ln, err = net.Listen(network, address); err != nil {
+		return nil, err
+	}
+
+	if err := a.RegisterListener(name, ln); err != nil {
+		ln.Close()
+		return nil, err
+	}
+
+	return ln, nil
+}
+
+// RegisterListener registers ln under the given name so that it may be
+// handed off to a new process during a graceful restart (see GracefulRestart)
+// and retrieved there using Listener or Listen. The Listener must provide a
+// File method (such as *net.TCPListener and *net.UnixListener do). As with
+// activated sockets, registered Listeners are closed automatically during
+// shutdown.
+func RegisterListener(name string, ln net.Listener) error {
+	return std.RegisterListener(name, ln)
+}
+
+// RegisterListener registers ln with a under the given name; see the
+// package-level RegisterListener for details.
+func (a *App) RegisterListener(name string, ln net.Listener) error {
+	if _, ok := ln.(filer); !ok {
+		return fmt.Errorf("listener %q (%T) cannot be registered: no File method", name, ln)
+	}
+
+	a.sockmutex.Lock()
+	defer a.sockmutex.Unlock()
+
+	a.sockets = append(a.sockets, &activatedSocket{name: name, ln: ln})
+
+	return nil
+}
+
+type filer interface {
+	File() (*os.File, error)
+}
+
+// handoffFile returns a file for the socket suitable for passing to a child
+// process.
+func (as *activatedSocket) handoffFile() (*os.File, error) {
+	if as.file != nil {
+		return as.file, nil
+	}
+	return as.ln.(filer).File()
+}
+
+// handedOff is called once the socket has been taken over by a new process
+// (see Restart) so that closing a unix socket's listener no longer removes
+// the socket file the new process is now serving.
+func (as *activatedSocket) handedOff() {
+	if ul, ok := as.ln.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(false)
+	}
+}
+
+// findSocket must be called with a.sockmutex held.
+func (a *App) findSocket(name string) (*activatedSocket, error) {
+	for _, as := range a.sockets {
+		if as.name == name {
+			return as, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrNoSocket, name)
+}
+
+// closeSockets closes and forgets all of a's sockets.
+func (a *App) closeSockets() {
+	a.sockmutex.Lock()
+	defer a.sockmutex.Unlock()
+
+	for _, as := range a.sockets {
+		if as.ln != nil {
+			as.ln.Close()
+		}
+		if as.pc != nil {
+			as.pc.Close()
+		}
+		if as.file != nil {
+			as.file.Close()
+		}
+	}
+
+	a.sockets = nil
+}
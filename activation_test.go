@@ -0,0 +1,156 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+//go:build !windows
+// +build !windows
+
+package toolman
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const envActivationChild = "TOOLMAN_TEST_ACTIVATION_CHILD"
+
+// TestActivationChild is executed as a child process by TestActivation; it
+// reports the address of each socket it was passed on STDOUT.
+func TestActivationChild(t *testing.T) {
+	if os.Getenv(envActivationChild) == "" {
+		t.Skip("only run as a child of TestActivation")
+	}
+
+	defer func(a *App) { std = a }(std)
+	std = newStdApp()
+	std.setupActivation()
+
+	if _, err := Listener("nope"); !errors.Is(err, ErrNoSocket) {
+		t.Errorf("Listener(nope) == %v; wanted ErrNoSocket", err)
+	}
+
+	if ln, err := Listener("web"); err == nil {
+		if again, _ := Listener("web"); again != ln {
+			t.Error("repeated Listener(web) returned a different Listener")
+		}
+		fmt.Printf("web=%s\n", ln.Addr())
+	}
+
+	if pc, err := PacketConn("dns"); err == nil {
+		fmt.Printf("dns=%s\n", pc.LocalAddr())
+	}
+
+	std.closeSockets()
+
+	if _, err := Listener("web"); !errors.Is(err, ErrNoSocket) {
+		t.Errorf("Listener(web) after close == %v; wanted ErrNoSocket", err)
+	}
+
+	for _, v := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES", envListenPPID} {
+		if os.Getenv(v) != "" {
+			t.Errorf("%s not unset", v)
+		}
+	}
+}
+
+func TestActivation(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	lnf, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lnf.Close()
+
+	pcf, err := pc.(*net.UDPConn).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pcf.Close()
+
+	activated := []string{"web=" + ln.Addr().String(), "dns=" + pc.LocalAddr().String()}
+
+	cases := []struct {
+		name string
+		pid  string // "self" for the child's own PID
+		ppid string
+		want []string
+	}{
+		{name: "systemd", pid: "self", want: activated},
+		{name: "restart", ppid: strconv.Itoa(os.Getpid()), want: activated},
+		{name: "other-pid", pid: "1", want: nil},
+		{name: "other-ppid", ppid: "1", want: nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// exec preserves the shell's PID, which is then known in advance
+			script := `exec "$0" "$@"`
+			if tc.pid == "self" {
+				script = `LISTEN_PID=$$ ` + script
+			}
+
+			cmd := exec.Command("/bin/sh", "-c", script, os.Args[0], "-test.run=^TestActivationChild$")
+			cmd.ExtraFiles = []*os.File{lnf, pcf}
+			cmd.Env = append(os.Environ(),
+				envActivationChild+"=1",
+				"LISTEN_FDS=2",
+				"LISTEN_FDNAMES=web:dns",
+			)
+			if tc.pid != "" && tc.pid != "self" {
+				cmd.Env = append(cmd.Env, "LISTEN_PID="+tc.pid)
+			}
+			if tc.ppid != "" {
+				cmd.Env = append(cmd.Env, envListenPPID+"="+tc.ppid)
+			}
+
+			out, err := cmd.CombinedOutput()
+			if err != nil {
+				t.Fatalf("child failed: %v\n%s", err, out)
+			}
+
+			var got []string
+			for _, line := range strings.Split(string(out), "\n") {
+				if strings.HasPrefix(line, "web=") || strings.HasPrefix(line, "dns=") {
+					got = append(got, line)
+				}
+			}
+
+			if fmt.Sprint(got) != fmt.Sprint(tc.want) {
+				t.Errorf("child found sockets %q; wanted %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package toolman
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"toolman.org/base/toolman/v2/internal/hooks"
+)
+
+// An App is an independent toolman lifecycle with its own InitFuncs,
+// ShutdownFuncs, configuration and FlagSet. Most programs have no need for
+// an App; the package-level functions (Init, RegisterInit, RegisterShutdown,
+// Shutdown, etc.) all operate on a default App representing the current
+// process. Additional Apps may be created with NewApp to manage several
+// components within a single process, such as in an integration test
+// harness.
+//
+// Note that logging is configured process-wide, so an App created by NewApp
+// ignores all logging related InitOptions. Also, unlike the default App,
+// Shutdown and Abort on such an App do not terminate the process; they
+// return once all of the App's applicable ShutdownFuncs have been executed.
+type App struct {
+	name    string
+	flagSet *pflag.FlagSet
+
+	initmutex   sync.Mutex
+	initialized bool
+	initfuncs   []*initAction
+
+	downmutex   sync.Mutex
+	finalized   bool
+	downactions []*shutdownAction
+	reportFile  string
+	catchPanics bool
+	sdNotify    bool
+	exitFunc    func(int) // nil for Apps that do not exit the process
+	stderr      io.Writer
+
+	sockmutex sync.Mutex
+	sockets   []*activatedSocket // activated or registered sockets
+
+	reloadmutex sync.Mutex
+	reloadfuncs []*reloadAction
+
+	outmutex  sync.Mutex
+	daemonOut string // file receiving a daemon's STDOUT and STDERR
+
+	ctx       context.Context // cancelled when shutdown begins
+	cancelCtx context.CancelFunc
+	workers   sync.WaitGroup
+
+	runmutex  sync.Mutex
+	runCancel context.CancelFunc // set by Run
+	signaled  bool
+	sigCount  int  // number of shutdown signals received
+	forceCode int  // exit code upon a second shutdown signal
+	forceDump bool // dump stacks upon a second shutdown signal
+
+	restartmutex   sync.Mutex // serializes calls to Restart
+	restartTimeout time.Duration
+	pidFile        *pidFile
+	instance       *instance // set by Init when using SingleInstance
+	handoff        *handoff  // pending handoff from our parent (see Restart)
+}
+
+// std is the default App operated on by the package-level functions.
+var std = newStdApp()
+
+func newStdApp() *App {
+	return newApp(&App{
+		exitFunc: os.Exit,
+		stderr:   os.Stderr,
+	})
+}
+
+// newApp completes the construction of a.
+func newApp(a *App) *App {
+	a.ctx, a.cancelCtx = context.WithCancel(context.Background())
+	return a
+}
+
+// NewApp returns a new App with its own, empty FlagSet that is independent
+// of all other Apps (including the default App used by the package-level
+// functions). The given name is used for the App's FlagSet.
+func NewApp(name string) *App {
+	return newApp(&App{
+		name:    name,
+		flagSet: pflag.NewFlagSet(name, pflag.ContinueOnError),
+		stderr:  os.Stderr,
+	})
+}
+
+// FlagSet returns the FlagSet used by a. For the default App, this is the
+// global pflag.CommandLine FlagSet.
+func (a *App) FlagSet() *pflag.FlagSet {
+	if a.isStd() {
+		return pflag.CommandLine
+	}
+	return a.flagSet
+}
+
+// Args returns the non-flag arguments remaining after a's FlagSet has been
+// parsed by Init.
+func (a *App) Args() []string {
+	return a.FlagSet().Args()
+}
+
+func (a *App) isStd() bool {
+	return a == std
+}
+
+func init() {
+	hooks.Snapshot = func() func() {
+		r := std.registrations()
+		return func() { std = r.newStdApp() }
+	}
+	hooks.SetExit = func(exit func(int), w io.Writer) { std.setExit(exit, w) }
+}
+
+func (a *App) setExit(exit func(int), w io.Writer) {
+	a.downmutex.Lock()
+	defer a.downmutex.Unlock()
+
+	a.exitFunc = exit
+	a.stderr = w
+}
+
+// registrations holds copies of the functions registered with an App.
+type registrations struct {
+	initfuncs   []*initAction
+	downactions []*shutdownAction
+	reloadfuncs []*reloadAction
+}
+
+// registrations returns a copy of the InitFuncs, ShutdownFuncs and
+// ReloadFuncs currently registered with a.
+func (a *App) registrations() *registrations {
+	r := &registrations{}
+
+	a.initmutex.Lock()
+	defer a.initmutex.Unlock()
+	a.downmutex.Lock()
+	defer a.downmutex.Unlock()
+	a.reloadmutex.Lock()
+	defer a.reloadmutex.Unlock()
+
+	r.copyFrom(a.initfuncs, a.downactions, a.reloadfuncs)
+
+	return r
+}
+
+// newStdApp returns a new default App with its own copy of the functions
+// registered in r.
+func (r *registrations) newStdApp() *App {
+	a := newStdApp()
+	c := &registrations{}
+	c.copyFrom(r.initfuncs, r.downactions, r.reloadfuncs)
+	a.initfuncs, a.downactions, a.reloadfuncs = c.initfuncs, c.downactions, c.reloadfuncs
+	return a
+}
+
+func (r *registrations) copyFrom(inits []*initAction, downs []*shutdownAction, reloads []*reloadAction) {
+	for _, ia := range inits {
+		c := *ia
+		r.initfuncs = append(r.initfuncs, &c)
+	}
+
+	for _, sa := range downs {
+		c := *sa
+		r.downactions = append(r.downactions, &c)
+	}
+
+	for _, ra := range reloads {
+		c := *ra
+		r.reloadfuncs = append(r.reloadfuncs, &c)
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package toolman
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestAppFlags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "toolman-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app.pid")
+
+	a := NewApp(t.Name())
+	if err := a.InitE(CommandArgs("--pidfile", path, "arg"), PIDFile("")); err != nil {
+		t.Fatalf("InitE() failed: %v", err)
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("--pidfile not honored: %v", err)
+	}
+
+	if f := pflag.CommandLine.Lookup("pidfile"); f != nil {
+		t.Error("--pidfile defined on global FlagSet")
+	}
+
+	if args := a.Args(); len(args) != 1 || args[0] != "arg" {
+		t.Errorf("Args() == %q; wanted [arg]", args)
+	}
+
+	a.Shutdown()
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("pid file not removed: %v", err)
+	}
+}
+
+func TestAppInitFailure(t *testing.T) {
+	a := NewApp(t.Name())
+	a.stderr = ioutil.Discard
+
+	var aborted bool
+	a.RegisterShutdown(func() { aborted = true }, OnAbort())
+	a.RegisterInitE(func() error { return errors.New("failed") })
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("Init() returned after a failing InitFunc")
+		}
+
+		if !aborted {
+			t.Error("OnAbort ShutdownFunc not executed")
+		}
+	}()
+
+	a.Init(CommandArgs())
+}
+
+func TestAppInitPanic(t *testing.T) {
+	a := NewApp(t.Name())
+	a.RegisterInit(func() { panic("boom") })
+
+	err := a.InitE(CommandArgs())
+
+	var ife *InitFuncError
+	if !errors.As(err, &ife) {
+		t.Fatalf("InitE() == %v; wanted an *InitFuncError", err)
+	}
+
+	var pe *PanicError
+	if !errors.As(err, &pe) || pe.Value != "boom" {
+		t.Errorf("InitE() == %v; wanted a *PanicError for %q", err, "boom")
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package toolman
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"toolman.org/base/log/v2"
+)
+
+// envDaemon is set in the environment of a daemonized child process. Its
+// value is the path of the file receiving the daemon's STDOUT and STDERR.
+const envDaemon = "TOOLMAN_DAEMON"
+
+// daemonize re-executes the current program as a detached daemon process and
+// waits for it to report the outcome of its Init. The current (parent)
+// process then exits: with status 0 if the daemon initialized successfully,
+// or otherwise with the exit code reported by the daemon (or EX_UNAVAILABLE
+// if it reported none). In the daemon process itself (or in its
+// replacement following a graceful restart; see Restart), daemonize merely
+// returns nil.
+//
+// The daemon is started in a new session (via setsid) with its STDIN
+// redirected from /dev/null and its STDOUT and STDERR appended to a file in
+// logDir (or /dev/null if that file cannot be opened).
+func (a *App) daemonize(logDir string) error {
+	if out := os.Getenv(envDaemon); out != "" {
+		os.Unsetenv(envDaemon)
+		a.outmutex.Lock()
+		a.daemonOut = out
+		a.outmutex.Unlock()
+		return nil
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	devnull, err := os.Open(os.DevNull)
+	if err != nil {
+		return err
+	}
+	defer devnull.Close()
+
+	outpath := filepath.Join(logDir, CommandName()+".out")
+	out, err := os.OpenFile(outpath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		log.Warningf("daemon output discarded: %v", err)
+		outpath = os.DevNull
+		if out, err = os.OpenFile(os.DevNull, os.O_WRONLY, 0); err != nil {
+			return err
+		}
+	}
+	defer out.Close()
+
+	rp, wp, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer rp.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin = devnull
+	cmd.Stdout = out
+	cmd.Stderr = out
+	cmd.ExtraFiles = []*os.File{wp}
+	cmd.SysProcAttr = detachedProcAttr()
+	cmd.Env = append(os.Environ(),
+		envDaemon+"="+outpath,
+		envReadyFD+"="+strconv.Itoa(listenFdsStart),
+	)
+
+	err = cmd.Start()
+	wp.Close()
+	if err != nil {
+		return fmt.Errorf("starting daemon: %v", err)
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	code := EX_OK
+	err = waitReady(rp, exited, 0)
+	if err != nil {
+		code = exitCode(err, EX_UNAVAILABLE)
+	}
+
+	switch {
+	case err == nil:
+		log.Infof("daemon started with pid %d", cmd.Process.Pid)
+
+	case code == EX_OK:
+		log.Infof("daemon (pid %d) exited: %v", cmd.Process.Pid, err)
+
+	default:
+		fmt.Fprintf(a.stderr, "daemon (pid %d) %v (see %s)\n", cmd.Process.Pid, err, outpath)
+	}
+
+	log.Flush()
+	a.exitFunc(code)
+
+	return nil
+}
+
+// reopenOutput reopens the file receiving the daemon's STDOUT and STDERR so
+// that, once it has been renamed (e.g. by logrotate), output is written to a
+// new file at the original path.
+func (a *App) reopenOutput() error {
+	a.outmutex.Lock()
+	defer a.outmutex.Unlock()
+
+	if a.daemonOut == "" || a.daemonOut == os.DevNull {
+		return nil
+	}
+
+	out, err := os.OpenFile(a.daemonOut, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	for _, fd := range []int{1, 2} {
+		if err := dupFD(int(out.Fd()), fd); err != nil {
+			return fmt.Errorf("redirecting fd %d to %s: %v", fd, a.daemonOut, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+//go:build !windows
+// +build !windows
+
+package toolman
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	envDaemonChild   = "TOOLMAN_TEST_DAEMON_CHILD"
+	envDaemonPIDFile = "TOOLMAN_TEST_DAEMON_PIDFILE"
+)
+
+// daemonChild is executed by TestDaemonize in a child process, which then
+// daemonizes itself by re-executing the test binary. Both the launching
+// process and the daemon therefore run daemonChild with the same name.
+func daemonChild(name string) {
+	opts := []*InitOption{CommandArgs(), Quiet(), Daemonize()}
+
+	switch name {
+	case "exit-error":
+		RegisterInitE(func() error { return NewExitError(EX_CONFIG, "bad config") })
+
+	case "locked-pidfile":
+		opts = append(opts, PIDFile(os.Getenv(envDaemonPIDFile)))
+
+	case "forwarded":
+		opts = append(opts, SingleInstance("TestDaemonize"), ForwardArgs(func([]string) {}))
+	}
+
+	Init(opts...)
+	Shutdown()
+}
+
+func TestDaemonize(t *testing.T) {
+	if name := os.Getenv(envDaemonChild); name != "" {
+		daemonChild(name)
+		return
+	}
+
+	defer runtimeDir(t)()
+
+	dir, err := ioutil.TempDir("", "toolman-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pidpath := filepath.Join(dir, "test.pid")
+	pf, err := lockPIDFile(pidpath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pf.remove()
+
+	running := NewApp("running")
+	if err := running.InitE(CommandArgs(), SingleInstance(t.Name()), ForwardArgs(func([]string) {})); err != nil {
+		t.Fatalf("InitE() failed: %v", err)
+	}
+	defer running.Shutdown()
+
+	cases := []struct {
+		name string
+		code int
+	}{
+		{name: "ready", code: EX_OK},
+		{name: "exit-error", code: EX_CONFIG},
+		{name: "locked-pidfile", code: EX_TEMPFAIL},
+		{name: "forwarded", code: EX_OK},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], "-test.run=^TestDaemonize$")
+			cmd.Env = append(os.Environ(),
+				envDaemonChild+"="+tc.name,
+				envDaemonPIDFile+"="+pidpath,
+				"TOOLMAN_LOGDIR="+dir,
+			)
+
+			if err := cmd.Start(); err != nil {
+				t.Fatal(err)
+			}
+
+			timer := time.AfterFunc(30*time.Second, func() { cmd.Process.Kill() })
+			defer timer.Stop()
+
+			var code int
+			if err := cmd.Wait(); err != nil {
+				ee, ok := err.(*exec.ExitError)
+				if !ok {
+					t.Fatal(err)
+				}
+				code = ee.ExitCode()
+			}
+
+			if code != tc.code {
+				t.Errorf("launching process exited with code %d; wanted %d", code, tc.code)
+			}
+		})
+	}
+}
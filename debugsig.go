@@ -0,0 +1,87 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package toolman
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"time"
+
+	"toolman.org/base/log/v2"
+	"toolman.org/base/signals"
+)
+
+// setupDebugSignals installs handlers that write diagnostic dumps into dir;
+// a goroutine dump on SIGUSR1 and a heap profile on SIGUSR2. Debug signals
+// are not supported on Windows.
+func setupDebugSignals(dir string) {
+	if goroutineDumpSig == nil || heapDumpSig == nil {
+		log.Warning("DebugSignals is not supported on this platform")
+		return
+	}
+
+	if dir == "" {
+		dir = os.TempDir()
+	}
+
+	signals.RegisterHandler(func(os.Signal) bool {
+		go writeDump(dir, "goroutines", func(f *os.File) error {
+			return pprof.Lookup("goroutine").WriteTo(f, 2)
+		})
+		return true
+	}, goroutineDumpSig)
+
+	signals.RegisterHandler(func(os.Signal) bool {
+		go writeDump(dir, "heap", func(f *os.File) error {
+			runtime.GC() // get up-to-date statistics
+			return pprof.WriteHeapProfile(f)
+		})
+		return true
+	}, heapDumpSig)
+}
+
+// writeDump creates a new, timestamped file of the given kind in dir and
+// calls write to populate it. The resulting file path is logged.
+func writeDump(dir, kind string, write func(*os.File) error) {
+	name := fmt.Sprintf("%s.%s.%s.%d", CommandName(), kind, time.Now().Format("20060102-150405.000"), os.Getpid())
+	path := filepath.Join(dir, name)
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		log.Errorf("creating %s dump: %v", kind, err)
+		return
+	}
+
+	err = write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		log.Errorf("writing %s dump to %q: %v", kind, path, err)
+		return
+	}
+
+	log.Infof("wrote %s dump to %q", kind, path)
+}
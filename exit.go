@@ -0,0 +1,106 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package toolman
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Exit codes for use with Exit and ExitError, as defined by sysexits.h.
+const (
+	EX_OK          = 0  // successful termination
+	EX_USAGE       = 64 // command line usage error
+	EX_DATAERR     = 65 // data format error
+	EX_NOINPUT     = 66 // cannot open input
+	EX_NOUSER      = 67 // addressee unknown
+	EX_NOHOST      = 68 // host name unknown
+	EX_UNAVAILABLE = 69 // service unavailable
+	EX_SOFTWARE    = 70 // internal software error
+	EX_OSERR       = 71 // system error (e.g., can't fork)
+	EX_OSFILE      = 72 // critical OS file missing
+	EX_CANTCREAT   = 73 // can't create (user) output file
+	EX_IOERR       = 74 // input/output error
+	EX_TEMPFAIL    = 75 // temp failure; user is invited to retry
+	EX_PROTOCOL    = 76 // remote error in protocol
+	EX_NOPERM      = 77 // permission denied
+	EX_CONFIG      = 78 // configuration error
+)
+
+// ExitError is an error carrying the exit code with which a program should
+// terminate. When passed to Abort (either directly or wrapped by another
+// error), the program exits with Code instead of the default code of 1.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+// NewExitError returns an *ExitError with the given exit code and a message
+// formatted according to format and args.
+func NewExitError(code int, format string, args ...interface{}) *ExitError {
+	return &ExitError{Code: code, Err: fmt.Errorf(format, args...)}
+}
+
+func (e *ExitError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("exit status %d", e.Code)
+	}
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// exitCode returns the exit code requested by err (if it is, or wraps, an
+// *ExitError) or dflt otherwise.
+func exitCode(err error, dflt int) int {
+	var ee *ExitError
+	if errors.As(err, &ee) {
+		return ee.Code
+	}
+	return dflt
+}
+
+// Exit terminates the program with the given exit code. If code is zero,
+// Exit behaves like Shutdown; otherwise it behaves like Abort, executing all
+// ShutdownFuncs enabled for abort. If err is not nil, its Error() string will
+// be issued on STDERR just prior to termination.
+func Exit(code int, err error) {
+	std.Exit(code, err)
+}
+
+// Exit terminates a with the given exit code; see the package-level Exit for
+// details.
+func (a *App) Exit(code int, err error) {
+	var msg string
+	if err != nil {
+		msg = err.Error()
+	}
+
+	why := reasonAbort
+	if code == EX_OK {
+		why = reasonShutdown
+	}
+
+	a.shutdown(why, code, msg)
+}
@@ -21,10 +21,11 @@
 package toolman
 
 import (
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"os/user"
+	"runtime"
 	"strconv"
 	"syscall"
 	"time"
@@ -130,30 +131,112 @@ func addLogSpam() {
 	}
 }
 
-func setupStdSignals() {
-	signals.RegisterSoftHandler(func(os.Signal) bool {
-		log.V(1).Info("shutting down now")
-		go func() {
-			signals.Stop()
-			Shutdown()
-		}()
+func (a *App) setupStdSignals(withHUP bool) {
+	sigs := []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	if withHUP {
+		sigs = append(sigs, syscall.SIGHUP)
+	}
+
+	signals.RegisterSoftHandler(func(sig os.Signal) bool {
+		a.handleShutdownSignal(sig)
 		return true
-	}, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	}, sigs...)
 }
 
-func (c *config) writePIDFile() {
-	if c.pidfile == "" {
+// handleShutdownSignal initiates a shutdown upon receipt of the first
+// shutdown signal. Should another shutdown signal arrive once shutdown is
+// already underway, the program is forcibly terminated.
+func (a *App) handleShutdownSignal(sig os.Signal) {
+	a.runmutex.Lock()
+	a.sigCount++
+	escalate := a.sigCount > 1 || a.ctx.Err() != nil
+	a.runmutex.Unlock()
+
+	if escalate {
+		a.forceExit(sig)
 		return
 	}
 
-	if err := ioutil.WriteFile(c.pidfile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
-		log.Errorf("writing pid file %q: %v", c.pidfile, err)
-		return
+	log.V(1).Infof("received %v; shutting down now", sig)
+	go a.signalShutdown()
+}
+
+// forceExit immediately terminates the program, skipping any remaining
+// ShutdownFuncs, after (optionally) dumping all goroutine stacks.
+func (a *App) forceExit(sig os.Signal) {
+	a.runmutex.Lock()
+	code, dump := a.forceCode, a.forceDump
+	a.runmutex.Unlock()
+
+	if code == 0 {
+		code = 1
+		if ss, ok := sig.(syscall.Signal); ok {
+			code = 128 + int(ss)
+		}
+	}
+
+	log.Errorf("received %v during shutdown; forcing exit with status %d", sig, code)
+
+	if dump {
+		stacks := allStacks()
+		log.Errorf("goroutine stacks:\n%s", stacks)
+		// N.B. a.stderr is only modified by toolmantest
+		fmt.Fprintf(a.stderr, "%s\n", stacks)
+	}
+
+	log.Flush()
+
+	// N.B. a.exitFunc is only modified by toolmantest
+	if a.exitFunc != nil {
+		a.exitFunc(code)
+	}
+}
+
+func allStacks() []byte {
+	buf := make([]byte, 1<<16)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// writePIDFile writes and locks the PID file (see lockPIDFile), arranging for
+// its removal during shutdown. A PID file handed off by our parent process is
+// merely adopted; it is rewritten once the handoff is committed (see
+// Restart). Any failure is returned as an *ExitError; one not caused by
+// another running process carries EX_CANTCREAT.
+func (a *App) writePIDFile(pidfile string) error {
+	if pidfile == "" {
+		return nil
+	}
+
+	var (
+		pf  *pidFile
+		err error
+	)
+
+	if inherited := inheritedPIDFile(); inherited != nil {
+		pf, err = adoptPIDFile(pidfile, inherited)
+	} else {
+		pf, err = lockPIDFile(pidfile, nil)
 	}
 
-	RegisterShutdown(func() {
-		if err := os.Remove(c.pidfile); err != nil {
-			log.Warningf("pidfile %q not removed on shutdown: %v", c.pidfile, err)
+	if err != nil {
+		var ee *ExitError
+		if !errors.As(err, &ee) {
+			err = &ExitError{Code: EX_CANTCREAT, Err: err}
 		}
-	})
+		return err
+	}
+
+	a.runmutex.Lock()
+	a.pidFile = pf
+	a.runmutex.Unlock()
+
+	a.RegisterShutdown(pf.remove)
+
+	return nil
 }
@@ -0,0 +1,85 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package toolman
+
+import (
+	"bytes"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSignalEscalation(t *testing.T) {
+	cases := []struct {
+		name string
+		opts []*InitOption
+		sig  syscall.Signal
+		code int
+		dump bool
+	}{
+		{name: "default-sigint", sig: syscall.SIGINT, code: 130},
+		{name: "default-sigterm", sig: syscall.SIGTERM, code: 143},
+		{name: "code", opts: []*InitOption{SignalEscalation(EX_TEMPFAIL, false)}, sig: syscall.SIGTERM, code: EX_TEMPFAIL},
+		{name: "dump", opts: []*InitOption{SignalEscalation(0, true)}, sig: syscall.SIGINT, code: 130, dump: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var stderr bytes.Buffer
+			exited := make(chan int, 2)
+
+			a := NewApp(t.Name())
+			a.setExit(func(code int) { exited <- code }, &stderr)
+
+			started := make(chan struct{})
+			release := make(chan struct{})
+			a.RegisterShutdown(func() { close(started); <-release }, TimeAllowance(time.Minute))
+
+			if err := a.InitE(append([]*InitOption{CommandArgs()}, tc.opts...)...); err != nil {
+				t.Fatalf("InitE() failed: %v", err)
+			}
+
+			// The first signal starts a shutdown that blocks in our
+			// ShutdownFunc; the second forces an immediate exit.
+			a.handleShutdownSignal(tc.sig)
+			<-started
+			a.handleShutdownSignal(tc.sig)
+
+			select {
+			case code := <-exited:
+				if code != tc.code {
+					t.Errorf("forced exit code %d; wanted %d", code, tc.code)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("no exit forced by second signal")
+			}
+
+			// Let the original shutdown complete
+			close(release)
+			<-exited
+
+			if got := strings.Contains(stderr.String(), "goroutine "); got != tc.dump {
+				t.Errorf("stack dump written to STDERR: %t; wanted %t", got, tc.dump)
+			}
+		})
+	}
+}
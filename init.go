@@ -61,67 +61,276 @@ The following is a typical use case:
 package toolman // import "toolman.org/base/toolman/v2"
 
 import (
-	"sync"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
-	"github.com/spf13/pflag"
-
 	"toolman.org/base/log/v2"
-)
-
-var (
-	initialized bool
-	finalized   bool
-	initfuncs   []InitFunc
-	downactions []*shutdownAction
-	initmutex   sync.Mutex
-	downmutex   sync.Mutex
+	"toolman.org/base/runtimeutil"
 )
 
 // InitFunc is a function registered via RegisterInit.
 type InitFunc func()
 
+// InitFuncE is an error-returning function registered via RegisterInitE.
+type InitFuncE func() error
+
+type initAction struct {
+	id     string
+	name   string
+	after  []string
+	before []string
+	initFn InitFuncE
+}
+
+// InitFuncError is the error reported for a single registered InitFuncE
+// that returned an error. Func identifies the registered function as
+// reported by runtimeutil.FuncID.
+type InitFuncError struct {
+	Func string
+	Err  error
+}
+
+func (e *InitFuncError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Func, e.Err)
+}
+
+// Unwrap returns the error returned by the registered function.
+func (e *InitFuncError) Unwrap() error {
+	return e.Err
+}
+
+// InitErrors is returned by InitE when one or more registered InitFuncs
+// fail. Each failure is reported in execution order.
+type InitErrors []*InitFuncError
+
+func (e InitErrors) Error() string {
+	switch len(e) {
+	case 0:
+		return "no errors"
+	case 1:
+		return "init failed: " + e[0].Error()
+	}
+
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Error()
+	}
+
+	return fmt.Sprintf("init failed with %d errors: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// Is reports whether any of the failures matches target (see errors.Is).
+func (e InitErrors) Is(target error) bool {
+	for _, fe := range e {
+		if errors.Is(fe, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first failure, in execution order, that matches target and,
+// if one is found, sets target to that error (see errors.As). This allows,
+// for example, the *ExitError returned by a failing InitFuncE to determine
+// the program's exit code.
+func (e InitErrors) As(target interface{}) bool {
+	for _, fe := range e {
+		if errors.As(fe, target) {
+			return true
+		}
+	}
+	return false
+}
+
 // Init is the common initialization method for all toolman.org Go programs
 // and should usually be the first call at the top of main().  Zero or more
 // InitOptions may be provided to alter Init's behavior.
 //
 // Please note, Init may only be called once; any subsequent calls to Init
-// will cause a panic.
+// will cause a panic. Should initialization fail (e.g. if any function
+// registered with RegisterInitE returns an error or panics), Init passes the
+// error to Abort, terminating the program once all ShutdownFuncs enabled for
+// abort have been executed. The exit code is 1 unless the error is (or wraps)
+// an *ExitError, such as when a PIDFile is locked by another process. Use
+// InitE to handle these errors directly. If, instead, the program's arguments
+// were forwarded to an already running instance (see ForwardArgs), Init
+// terminates the program cleanly, as if by Exit(EX_OK, nil).
 func Init(opts ...*InitOption) {
-	initmutex.Lock()
-	defer initmutex.Unlock()
-	defer func() { initialized = true }()
+	std.Init(opts...)
+}
+
+// InitE is similar to Init except that, should initialization fail, the
+// error is returned to the caller instead of terminating the program. All
+// registered functions are executed, even if some fail; when one or more
+// return an error (or panic), the returned error is of type InitErrors,
+// holding an *InitFuncError for each failure. A panic in any registered
+// function is recovered, logged and reported as a *PanicError wrapped by its
+// *InitFuncError; errors.As may be used to find it. This allows main() to
+// decide how to react to initialization failures (e.g. by calling Abort).
+//
+// As with Init, InitE may only be called once and any subsequent calls to
+// either Init or InitE will cause a panic.
+func InitE(opts ...*InitOption) error {
+	return std.InitE(opts...)
+}
+
+// Init initializes a; see the package-level Init for details. Note that, for
+// an App created by NewApp, Abort and Exit do not terminate the process;
+// should initialization fail (or arguments be forwarded), Init panics once a
+// has been shut down. Use InitE to handle these errors instead.
+func (a *App) Init(opts ...*InitOption) {
+	err := a.InitE(opts...)
+	if err == nil {
+		return
+	}
 
-	if initialized {
+	if errors.Is(err, ErrForwarded) {
+		a.Exit(EX_OK, nil)
+	} else {
+		a.Abort(err)
+	}
+
+	panic(err)
+}
+
+// InitE initializes a; see the package-level InitE for details.
+func (a *App) InitE(opts ...*InitOption) (err error) {
+	a.initmutex.Lock()
+	defer a.initmutex.Unlock()
+	defer func() { a.initialized = true }()
+
+	if a.initialized {
 		panic("toolman.Init() called multiple times!")
 	}
 
-	cfg := newConfig(opts)
+	if a.isStd() {
+		// Report our success (or failure) to a waiting parent process, if
+		// any (see Restart and Daemonize).
+		defer func() { notifyParent(err) }()
+	}
 
-	pflag.Parse()
+	cfg := a.newConfig(opts)
+
+	if err := cfg.parseFlags(); err != nil {
+		return err
+	}
 
 	cfg.setup(opts)
 
 	if cfg.stdsigs {
-		setupStdSignals()
+		a.setupStdSignals(!cfg.reloadHUP)
 	}
 
-	if err := cfg.setupLogging(); err != nil {
-		panic(err)
+	if cfg.reloadHUP {
+		a.setupReloadSignal()
 	}
 
-	if cfg.logSpam {
-		addLogSpam()
+	if a.isStd() {
+		if err := cfg.setupLogging(); err != nil {
+			return err
+		}
+
+		if cfg.daemon {
+			if err := a.daemonize(cfg.logDir); err != nil {
+				return err
+			}
+		}
+
+		if h := inheritedHandoff(); h != nil {
+			a.runmutex.Lock()
+			a.handoff = h
+			a.runmutex.Unlock()
+		}
+
+		if cfg.logSpam {
+			addLogSpam()
+		}
+
+		if cfg.restartSig != nil {
+			a.setupRestartSignal(cfg.restartSig)
+		}
+
+		if cfg.debugSigs {
+			setupDebugSignals(cfg.logDir)
+		}
+
+		if cfg.cycleSig != nil {
+			setupVerbositySignal(cfg.cycleSig, cfg.cycleMax)
+		}
 	}
 
-	if cfg.pidfile != "" {
-		cfg.writePIDFile()
+	a.setupActivation()
+
+	if cfg.instKey != "" {
+		inst, err := a.acquireInstance(cfg.instKey, cfg.instWait, cfg.instFwd)
+		if err != nil {
+			return err
+		}
+		a.runmutex.Lock()
+		a.instance = inst
+		a.runmutex.Unlock()
 	}
 
-	RegisterShutdown(func() { log.Flush(); time.Sleep(5 * time.Millisecond) })
+	if err := a.writePIDFile(cfg.pidfile); err != nil {
+		return err
+	}
+
+	a.setupShutdown(cfg)
+
+	a.registerWorkerWait(cfg.workerAllow)
+
+	a.RegisterShutdown(func() { log.Flush(); time.Sleep(5 * time.Millisecond) }, Phase(finalPhase))
+
+	funcs, err := sortInitFuncs(a.initfuncs)
+	if err != nil {
+		return err
+	}
+
+	var errs InitErrors
+	for _, ia := range funcs {
+		err := callSafely(ia.initFn)
+		if err == nil {
+			continue
+		}
+
+		if pe, ok := err.(*PanicError); ok {
+			log.Errorf("init func %s panicked: %v\n%s", ia.id, pe.Value, pe.Stack)
+		} else {
+			log.Errorf("init func %s failed: %v", ia.id, err)
+		}
+
+		errs = append(errs, &InitFuncError{Func: ia.id, Err: err})
+	}
 
-	for _, f := range initfuncs {
-		f()
+	if len(errs) != 0 {
+		return errs
+	}
+
+	a.ready(cfg)
+
+	return nil
+}
+
+// ready is called once a has been successfully initialized.
+func (a *App) ready(cfg *config) {
+	if a.instance != nil {
+		a.instance.serve()
+	}
+
+	sd := cfg.sdNotify && a.isStd()
+
+	a.runmutex.Lock()
+	h := a.handoff
+	a.runmutex.Unlock()
+
+	switch {
+	case h != nil:
+		go a.awaitHandoff(h, sd)
+
+	case sd:
+		a.sdReady()
 	}
 }
 
@@ -149,21 +358,58 @@ func InitCLI() {
 // Init has been called, RegisterInit will log an error message indicating
 // that it refused to register the InitFunc.
 //
+// InitFuncs are executed in registration order unless their order is
+// constrained using the Named, After and Before InitFuncOptions. Init will
+// fail if these constraints cannot be satisfied.
+//
 // Functions registered via RegisterInit should avoid much heavy lifting as
 // there are likely many and each one is executed upon startup of every
 // participating Go program.  These functions also have no mechanism for
 // dealing with error conditions (other than reporting them via standard
 // logging) so risky operations should also be avoided. However, if your
 // library simply cannot function properly due to an initialization failure,
-// your registered functions should panic.
-func RegisterInit(f InitFunc) {
-	initmutex.Lock()
-	defer initmutex.Unlock()
+// your registered functions should panic (or, better yet, be registered
+// with RegisterInitE instead).
+func RegisterInit(f InitFunc, opts ...InitFuncOption) {
+	std.registerInit(runtimeutil.FuncID(f), func() error { f(); return nil }, opts)
+}
+
+// RegisterInitE is similar to RegisterInit except that the registered
+// function may return an error to indicate an initialization failure.
+// Errors returned by these functions are collected by InitE and returned
+// to the caller, identified by the function that returned them. If Init is
+// used instead of InitE, any such error causes Init to abort the program (see
+// Init for details).
+func RegisterInitE(f InitFuncE, opts ...InitFuncOption) {
+	std.registerInit(runtimeutil.FuncID(f), f, opts)
+}
 
-	if initialized {
-		log.ErrorDepth(1, "InitFunc not registered after call to Init()")
+// RegisterInit registers an InitFunc to be executed by a.Init; see the
+// package-level RegisterInit for details.
+func (a *App) RegisterInit(f InitFunc, opts ...InitFuncOption) {
+	a.registerInit(runtimeutil.FuncID(f), func() error { f(); return nil }, opts)
+}
+
+// RegisterInitE registers an InitFuncE to be executed by a.Init; see the
+// package-level RegisterInitE for details.
+func (a *App) RegisterInitE(f InitFuncE, opts ...InitFuncOption) {
+	a.registerInit(runtimeutil.FuncID(f), f, opts)
+}
+
+func (a *App) registerInit(id string, f InitFuncE, opts []InitFuncOption) {
+	a.initmutex.Lock()
+	defer a.initmutex.Unlock()
+
+	if a.initialized {
+		log.ErrorDepth(2, "InitFunc not registered after call to Init()")
 		return
 	}
 
-	initfuncs = append(initfuncs, f)
+	ia := &initAction{id: id, initFn: f}
+
+	for _, o := range opts {
+		o(ia)
+	}
+
+	a.initfuncs = append(a.initfuncs, ia)
 }
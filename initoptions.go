@@ -28,7 +28,11 @@ package toolman
 // tl/dr; Define flags in outer funcs, reference them from inner funcs.
 //        (see PIDFile as an example)
 //
+//        Such flags belong on the InitOption's own FlagSet, which is added to
+//        the App's FlagSet prior to parsing, rather than pflag.CommandLine.
+//
 import (
+	"os"
 	"time"
 
 	"github.com/spf13/pflag"
@@ -36,6 +40,14 @@ import (
 
 type config struct {
 	stdsigs     bool
+	reloadHUP   bool
+	debugSigs   bool
+	sdNotify    bool
+	cycleSig    os.Signal
+	cycleMax    int
+	restartSig  os.Signal
+	restartWait time.Duration
+	daemon      bool
 	logDir      string
 	mkLogDir    bool
 	logFiles    bool
@@ -45,14 +57,26 @@ type config struct {
 	logPrefix   string
 	logSuffix   string
 	pidfile     string
+	instKey     string
+	instWait    time.Duration
+	instFwd     func([]string)
+	reportFile  string
+	catchPanics bool
+	workerAllow time.Duration
+	forceCode   int
+	forceDump   bool
 	flagSet     *pflag.FlagSet
+	args        []string
+	app         *App
 }
 
-func newConfig(opts []*InitOption) *config {
+func (a *App) newConfig(opts []*InitOption) *config {
 	cfg := &config{
-		logSpam:  true,
-		logFiles: true,
-		flagSet:  pflag.CommandLine,
+		logSpam:     true,
+		logFiles:    true,
+		workerAllow: time.Second,
+		flagSet:     a.FlagSet(),
+		app:         a,
 	}
 
 	for _, o := range opts {
@@ -61,11 +85,35 @@ func newConfig(opts []*InitOption) *config {
 		}
 	}
 
-	pflag.CommandLine = cfg.flagSet
+	for _, o := range opts {
+		if o.flags != nil {
+			cfg.flagSet.AddFlagSet(o.flags)
+		}
+	}
+
+	if a.isStd() {
+		pflag.CommandLine = cfg.flagSet
+	} else {
+		a.flagSet = cfg.flagSet
+	}
 
 	return cfg
 }
 
+func (c *config) parseFlags() error {
+	if c.app.isStd() && c.args == nil {
+		pflag.Parse()
+		return nil
+	}
+
+	args := c.args
+	if args == nil {
+		args = os.Args[1:]
+	}
+
+	return c.flagSet.Parse(args)
+}
+
 func (c *config) setup(opts []*InitOption) {
 	for _, o := range opts {
 		if o.setup != nil {
@@ -82,12 +130,14 @@ type InitOption struct {
 	init cfgFunc
 	// setup functions are called *after* flags are parsed
 	setup cfgFunc
+	// flags are added to the App's FlagSet (even within If) before parsing
+	flags *pflag.FlagSet
 	// applied is true when an If condition is true
 	applied bool
 }
 
 func If(cond func() bool, options ...*InitOption) *InitOption {
-	ret := &InitOption{}
+	ret := &InitOption{flags: mergeFlags(options)}
 
 	ret.setup = func(c *config) {
 		if ret.applied = cond(); ret.applied {
@@ -102,6 +152,7 @@ func If(cond func() bool, options ...*InitOption) *InitOption {
 
 func (o *InitOption) Else(options ...*InitOption) *InitOption {
 	return &InitOption{
+		flags: mergeFlags(options),
 		setup: func(c *config) {
 			if !o.applied {
 				for _, opt := range options {
@@ -112,6 +163,22 @@ func (o *InitOption) Else(options ...*InitOption) *InitOption {
 	}
 }
 
+// mergeFlags returns a FlagSet holding the flags of all given options, or nil
+// if they have none.
+func mergeFlags(options []*InitOption) *pflag.FlagSet {
+	var fs *pflag.FlagSet
+	for _, o := range options {
+		if o.flags == nil {
+			continue
+		}
+		if fs == nil {
+			fs = pflag.NewFlagSet("", pflag.ContinueOnError)
+		}
+		fs.AddFlagSet(o.flags)
+	}
+	return fs
+}
+
 // FlagSet returns an InitOption that makes fs the primary FlagSet for this
 // application. All flags from the existing FlagSet will be merged into fs.
 // Flag names already present will be overwritten by those from fs.
@@ -124,6 +191,17 @@ func FlagSet(fs *pflag.FlagSet) *InitOption {
 	}
 }
 
+// CommandArgs returns an InitOption that causes Init to parse args instead
+// of the current process' command line arguments (i.e. os.Args[1:]). This is
+// most useful with an App created by NewApp.
+func CommandArgs(args ...string) *InitOption {
+	return &InitOption{
+		init: func(c *config) {
+			c.args = append([]string{}, args...)
+		},
+	}
+}
+
 // AddFlagSet returns an InitOption that adds one or more additional FlagSets
 // to the primary FlagSet for this application. Preexisting flags will not
 // be overwritten.
@@ -189,20 +267,190 @@ func Quiet() *InitOption {
 }
 
 // StandardSignals returns an InitOption that sets up signal handlers to
-// shutdown the program on receipt of SIGHUP, SIGINT or SIGTERM. For more
-// fine-grained control of shutdown behavior, see toolman.RegisterShutdown
-// and toolman.ShutdownOn.
+// shutdown the program on receipt of SIGHUP, SIGINT or SIGTERM (or, when
+// using Run, to cancel the Context passed to main). If ReloadOnHUP is also
+// provided, SIGHUP will instead cause the program to reload. For more fine-grained
+// control of shutdown behavior, see toolman.RegisterShutdown and
+// toolman.ShutdownOn.
 func StandardSignals() *InitOption {
 	return &InitOption{setup: func(c *config) { c.stdsigs = true }}
 }
 
-var pidfilename *string
+// ReloadOnHUP returns an InitOption that causes the program to reload upon
+// receipt of SIGHUP, as is conventional for daemons, instead of shutting
+// down. A reload flushes all pending log output and, for a daemon (see
+// Daemonize), reopens the file receiving its STDOUT and STDERR so that it may
+// be rotated by tools such as logrotate. Each ReloadFunc registered with
+// RegisterReload is then executed, and its result logged.
+//
+// A reload does not itself re-read flags or configuration files; toolman
+// knows only of the command line parsed by Init. Programs whose settings live
+// in such files should re-read them with a ReloadFunc (updating flags via
+// FlagSet().Set as necessary). Likewise, files written by the log package are
+// not reopened; each is uniquely named when created and rotated by the log
+// package itself.
+func ReloadOnHUP() *InitOption {
+	return &InitOption{setup: func(c *config) { c.reloadHUP = true }}
+}
+
+// DebugSignals returns an InitOption that installs signal handlers for
+// gathering diagnostics from a running program without disturbing it. On
+// receipt of SIGUSR1, a full goroutine dump is written and, on receipt of
+// SIGUSR2, a heap profile is written. Each is written to a new, timestamped
+// file in the log directory (see LogDir) and its path is logged. Since these
+// signal handlers serve the process as a whole, this option is ignored by an
+// App created with NewApp. Debug signals are not supported on Windows.
+func DebugSignals() *InitOption {
+	return &InitOption{setup: func(c *config) { c.debugSigs = true }}
+}
+
+// CycleVerbosityOn returns an InitOption that cycles the log verbosity level
+// (see SetVerbosity) on each receipt of sig; the level is incremented until it
+// exceeds max, at which point it returns to 0. For example, passing SIGUSR2
+// and 2 allows log.V(1) and log.V(2) logging to be enabled (and disabled) at
+// runtime. Note that this conflicts with DebugSignals if sig is SIGUSR1 or
+// SIGUSR2. Since the log verbosity is process-wide, this option is ignored by
+// an App created with NewApp.
+func CycleVerbosityOn(sig os.Signal, max int) *InitOption {
+	return &InitOption{setup: func(c *config) {
+		c.cycleSig = sig
+		c.cycleMax = max
+	}}
+}
+
+// SystemdNotify returns an InitOption that integrates the program's lifecycle
+// with systemd's service notification protocol (for use with Type=notify
+// services). READY=1 is sent once Init has successfully executed all InitFuncs
+// and STOPPING=1 is sent as soon as shutdown begins. If systemd's watchdog is
+// enabled (i.e. WATCHDOG_USEC is set), WATCHDOG=1 is also sent at half the
+// configured interval until shutdown begins. See Restart for how a graceful
+// restart is reported to systemd, and see also SetStatus.
+//
+// If the NOTIFY_SOCKET environment variable is unset, this option has no
+// effect. Since these notifications describe the process as a whole, this
+// option is also ignored by an App created with NewApp.
+func SystemdNotify() *InitOption {
+	return &InitOption{setup: func(c *config) { c.sdNotify = true }}
+}
+
+// GracefulRestart returns an InitOption that causes the program to perform a
+// graceful restart (see Restart) on receipt of sig. The new process must
+// become ready within timeout, or 30s if timeout is zero. Listeners to be
+// handed off to the new process should be created with Listen (or registered
+// using RegisterListener).
+func GracefulRestart(sig os.Signal, timeout time.Duration) *InitOption {
+	return &InitOption{setup: func(c *config) {
+		c.restartSig = sig
+		c.restartWait = timeout
+	}}
+}
+
+// Daemonize returns an InitOption that causes toolman.Init to detach the
+// program from its controlling terminal and continue running in the
+// background as a daemon. This InitOption also registers a new --daemon flag
+// (defaulting to true) that allows the user to keep the program in the
+// foreground by passing --daemon=false.
+//
+// Daemonizing is accomplished by re-executing the program in a new session
+// with its STDIN redirected from /dev/null and its STDOUT and STDERR appended
+// to a file named for the program in the log directory. The launching process
+// waits for the daemon to complete Init (including all InitFuncs) and then
+// exits; should the daemon fail to initialize, the launching process exits
+// with the same exit code as the daemon. Any PID file (see PIDFile) is
+// written by the daemon process. Daemonize is not supported on Windows.
+func Daemonize() *InitOption {
+	fs := pflag.NewFlagSet("daemon", pflag.ContinueOnError)
+	daemon := fs.Bool("daemon", true, "Run in the background as a daemon")
+	return &InitOption{flags: fs, setup: func(c *config) { c.daemon = *daemon }}
+}
+
+// SignalEscalation returns an InitOption that configures how the program
+// reacts to a second shutdown signal (see StandardSignals and ShutdownOn)
+// received while it is already shutting down. In this case, any remaining
+// ShutdownFuncs are skipped and the program exits immediately with the given
+// exit code. If code is 0, the exit code will be 128 plus the number of the
+// received signal (e.g. 130 for SIGINT), which is also the default behavior
+// when this option is not provided. If dumpStacks is true, the stack traces
+// for all goroutines are written to STDERR and the log prior to exiting.
+func SignalEscalation(code int, dumpStacks bool) *InitOption {
+	return &InitOption{setup: func(c *config) {
+		c.forceCode = code
+		c.forceDump = dumpStacks
+	}}
+}
+
+// CatchPanics returns an InitOption that causes a deferred call to Shutdown
+// to recover from any panic in main's goroutine. Instead of exiting cleanly
+// (and losing the panic value), the panic and its stack trace are logged and
+// the program is terminated via Abort with a non-zero exit code. See Go for
+// similar handling of panics in other goroutines.
+func CatchPanics() *InitOption {
+	return &InitOption{setup: func(c *config) { c.catchPanics = true }}
+}
+
+// WorkerAllowance returns an InitOption that changes the default 1s that
+// Shutdown waits for workers tracked by WaitFor to complete. If d is not
+// positive, Shutdown does not wait for them at all.
+func WorkerAllowance(d time.Duration) *InitOption {
+	return &InitOption{setup: func(c *config) { c.workerAllow = d }}
+}
 
 // PIDFile returns an InitOption that tells toolman.Init to write the current
 // process ID to the file named by dflt. This InitOption also registers a new
 // --pidfile flag that allows the user to change the PID file's path on
 // invocation.
+//
+// The PID file is written atomically and an exclusive lock (via flock) is
+// held on it for the lifetime of the process. If another running process
+// already holds this lock, Init fails with an *ExitError carrying EX_TEMPFAIL
+// (or EX_CANTCREAT if the PID file cannot be written at all). On shutdown,
+// the PID file is removed only if it still contains the current process ID.
+// On Windows, the PID file is written but not locked.
 func PIDFile(dflt string) *InitOption {
-	pidfilename = pflag.String("pidfile", dflt, "Path to file where PID is written")
-	return &InitOption{setup: func(c *config) { c.pidfile = *pidfilename }}
+	fs := pflag.NewFlagSet("pidfile", pflag.ContinueOnError)
+	pidfile := fs.String("pidfile", dflt, "Path to file where PID is written")
+	return &InitOption{flags: fs, setup: func(c *config) { c.pidfile = *pidfile }}
+}
+
+// SingleInstance returns an InitOption that prevents more than one instance
+// of the program, identified by key, from running concurrently for the
+// current user. Init acquires a lock for key (a lock file, along with a unix
+// socket for use by ForwardArgs, in a directory accessible only to the
+// current user under $XDG_RUNTIME_DIR or the system's temporary directory)
+// which is held until the program terminates. If another instance already
+// holds this lock, Init fails with an *ExitError carrying EX_TEMPFAIL; see
+// WaitInstance and ForwardArgs to alter this behavior. During a graceful
+// restart (see GracefulRestart), the lock is handed off to the new process.
+// SingleInstance is not supported on Windows.
+//
+// To enforce a single instance per data directory (rather than per program),
+// include the directory's path in key, e.g.:
+//
+//	toolman.SingleInstance("mytool:" + dataDir)
+func SingleInstance(key string) *InitOption {
+	return &InitOption{setup: func(c *config) { c.instKey = key }}
+}
+
+// WaitInstance returns an InitOption that causes Init to wait up to timeout
+// for a running instance to exit (see SingleInstance) before giving up.
+func WaitInstance(timeout time.Duration) *InitOption {
+	return &InitOption{setup: func(c *config) { c.instWait = timeout }}
+}
+
+// ForwardArgs returns an InitOption that enables the forwarding of command
+// line arguments between instances of a program using SingleInstance. Rather
+// than failing, a new invocation sends its non-flag arguments (see Args) to
+// the running instance, which passes them to f. Init then terminates the
+// program cleanly, as if by Exit(EX_OK, nil), while InitE instead returns an
+// *ExitError wrapping ErrForwarded with a Code of EX_OK. The running instance
+// calls f only once it has completed Init.
+func ForwardArgs(f func(args []string)) *InitOption {
+	return &InitOption{setup: func(c *config) { c.instFwd = f }}
+}
+
+// ShutdownReportFile returns an InitOption that causes a ShutdownReport to be
+// written, in JSON format, to the file named by path when the program
+// terminates via Shutdown or Abort.
+func ShutdownReportFile(path string) *InitOption {
+	return &InitOption{setup: func(c *config) { c.reportFile = path }}
 }
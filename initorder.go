@@ -0,0 +1,196 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package toolman
+
+import (
+	"fmt"
+	"strings"
+
+	"toolman.org/base/log/v2"
+)
+
+// InitFuncOption is used to modify the behavior of InitFuncs registered by
+// RegisterInit or RegisterInitE.
+type InitFuncOption func(ia *initAction)
+
+// Named returns an InitFuncOption that assigns name to the registered
+// InitFunc so that other InitFuncs may refer to it using After or Before.
+// Names must be unique; Init will fail if two InitFuncs share the same name.
+func Named(name string) InitFuncOption {
+	return func(ia *initAction) {
+		ia.name = name
+	}
+}
+
+// After returns an InitFuncOption indicating that the registered InitFunc
+// must be executed after each of the InitFuncs with the given names. Names
+// that have not been registered (e.g. from a library that isn't linked into
+// the current program) are ignored.
+func After(names ...string) InitFuncOption {
+	return func(ia *initAction) {
+		ia.after = append(ia.after, names...)
+	}
+}
+
+// Before returns an InitFuncOption indicating that the registered InitFunc
+// must be executed before each of the InitFuncs with the given names. As
+// with After, unknown names are ignored.
+func Before(names ...string) InitFuncOption {
+	return func(ia *initAction) {
+		ia.before = append(ia.before, names...)
+	}
+}
+
+func (ia *initAction) String() string {
+	if ia.name != "" {
+		return ia.name
+	}
+	return ia.id
+}
+
+// sortInitFuncs returns the given InitFuncs topologically sorted according to
+// their After and Before constraints. InitFuncs without constraints between
+// them retain their relative registration order. An error is returned if
+// names are duplicated or the constraints contain a cycle.
+func sortInitFuncs(funcs []*initAction) ([]*initAction, error) {
+	byName := make(map[string]int)
+	for i, ia := range funcs {
+		if ia.name == "" {
+			continue
+		}
+		if j, ok := byName[ia.name]; ok {
+			return nil, fmt.Errorf("duplicate init func name %q: registered by both %s and %s", ia.name, funcs[j].id, ia.id)
+		}
+		byName[ia.name] = i
+	}
+
+	// edges[i] lists the indexes of InitFuncs that must run after funcs[i]
+	edges := make([][]int, len(funcs))
+	indeg := make([]int, len(funcs))
+
+	addEdge := func(from, to int) {
+		edges[from] = append(edges[from], to)
+		indeg[to]++
+	}
+
+	lookup := func(ia *initAction, name string) (int, bool) {
+		j, ok := byName[name]
+		if !ok {
+			log.V(1).Infof("init func %s: ignoring ordering constraint for unknown name %q", ia, name)
+		}
+		return j, ok
+	}
+
+	for i, ia := range funcs {
+		for _, n := range ia.after {
+			if j, ok := lookup(ia, n); ok {
+				addEdge(j, i)
+			}
+		}
+		for _, n := range ia.before {
+			if j, ok := lookup(ia, n); ok {
+				addEdge(i, j)
+			}
+		}
+	}
+
+	sorted := make([]*initAction, 0, len(funcs))
+	done := make([]bool, len(funcs))
+
+	// Always select the earliest registered InitFunc whose constraints have
+	// been satisfied; this keeps unconstrained InitFuncs in registration order.
+	for len(sorted) < len(funcs) {
+		next := -1
+		for i := range funcs {
+			if !done[i] && indeg[i] == 0 {
+				next = i
+				break
+			}
+		}
+
+		if next < 0 {
+			return nil, initCycleError(funcs, edges, done)
+		}
+
+		done[next] = true
+		sorted = append(sorted, funcs[next])
+		for _, j := range edges[next] {
+			indeg[j]--
+		}
+	}
+
+	return sorted, nil
+}
+
+// initCycleError locates one cycle amongst the InitFuncs not yet sorted and
+// returns an error describing it.
+func initCycleError(funcs []*initAction, edges [][]int, done []bool) error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make([]int, len(funcs))
+	var path []int
+
+	var visit func(int) []int
+	visit = func(i int) []int {
+		state[i] = visiting
+		path = append(path, i)
+		for _, j := range edges[i] {
+			if done[j] {
+				continue
+			}
+			switch state[j] {
+			case visiting:
+				for k, p := range path {
+					if p == j {
+						return append(append([]int(nil), path[k:]...), j)
+					}
+				}
+			case unvisited:
+				if c := visit(j); c != nil {
+					return c
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[i] = visited
+		return nil
+	}
+
+	for i := range funcs {
+		if done[i] || state[i] != unvisited {
+			continue
+		}
+		if c := visit(i); c != nil {
+			names := make([]string, len(c))
+			for k, j := range c {
+				names[k] = funcs[j].String()
+			}
+			return fmt.Errorf("init func ordering cycle: %s", strings.Join(names, " -> "))
+		}
+	}
+
+	// Not reached; unsorted InitFuncs always contain a cycle.
+	return fmt.Errorf("init func ordering cycle")
+}
@@ -0,0 +1,134 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package toolman
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSortInitFuncs(t *testing.T) {
+	type fn struct {
+		name   string
+		after  []string
+		before []string
+	}
+
+	cases := []struct {
+		name    string
+		funcs   []fn
+		want    []string
+		wantErr string
+	}{
+		{
+			name:  "registration-order",
+			funcs: []fn{{name: "a"}, {name: "b"}, {name: "c"}},
+			want:  []string{"a", "b", "c"},
+		},
+		{
+			name:  "after",
+			funcs: []fn{{name: "a", after: []string{"c"}}, {name: "b"}, {name: "c"}},
+			want:  []string{"b", "c", "a"},
+		},
+		{
+			name:  "before",
+			funcs: []fn{{name: "a"}, {name: "b"}, {name: "c", before: []string{"a"}}},
+			want:  []string{"b", "c", "a"},
+		},
+		{
+			name: "chain",
+			funcs: []fn{
+				{name: "a", after: []string{"b"}},
+				{name: "b", after: []string{"c"}},
+				{name: "c"},
+				{name: "d", before: []string{"c"}},
+			},
+			want: []string{"d", "c", "b", "a"},
+		},
+		{
+			name:  "unnamed",
+			funcs: []fn{{after: []string{"b"}}, {name: "b"}, {}},
+			want:  []string{"b", "", ""},
+		},
+		{
+			name:  "unknown-names",
+			funcs: []fn{{name: "a", after: []string{"nope"}}, {name: "b", before: []string{"nada"}}},
+			want:  []string{"a", "b"},
+		},
+		{
+			name:    "duplicate-names",
+			funcs:   []fn{{name: "a"}, {name: "b"}, {name: "a"}},
+			wantErr: `duplicate init func name "a"`,
+		},
+		{
+			name:    "self-cycle",
+			funcs:   []fn{{name: "a", after: []string{"a"}}},
+			wantErr: "init func ordering cycle: a -> a",
+		},
+		{
+			name: "cycle",
+			funcs: []fn{
+				{name: "x"},
+				{name: "a", after: []string{"b"}},
+				{name: "b", after: []string{"c"}},
+				{name: "c", after: []string{"a"}},
+			},
+			wantErr: "init func ordering cycle: a -> c -> b -> a",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var funcs []*initAction
+			for i, f := range tc.funcs {
+				funcs = append(funcs, &initAction{
+					id:     string(rune('0' + i)),
+					name:   f.name,
+					after:  f.after,
+					before: f.before,
+				})
+			}
+
+			sorted, err := sortInitFuncs(funcs)
+
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("sortInitFuncs() == (%v, %v); wanted error containing %q", sorted, err, tc.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("sortInitFuncs() failed: %v", err)
+			}
+
+			var got []string
+			for _, ia := range sorted {
+				got = append(got, ia.name)
+			}
+
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("sortInitFuncs() == %v; wanted %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package toolman
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"toolman.org/base/log/v2"
+)
+
+// ErrForwarded is wrapped by the *ExitError (with a Code of EX_OK) returned
+// by InitE when the current invocation's arguments have been forwarded to an
+// already running instance (see SingleInstance and ForwardArgs).
+var ErrForwarded = errors.New("arguments forwarded to running instance")
+
+// envInstanceFDs names the file descriptors of the single instance lock file
+// and socket, separated by a colon, inherited from a parent process during a
+// graceful restart (see Restart).
+const envInstanceFDs = "TOOLMAN_INSTANCE_FDS"
+
+// instanceIOTimeout bounds the exchange of forwarded arguments.
+const instanceIOTimeout = 5 * time.Second
+
+// An instance is the lock held by a program using SingleInstance. The lock is
+// held (via flock) for as long as lock remains open. Subsequent invocations
+// may forward their arguments to the running instance over ln.
+type instance struct {
+	key     string
+	lock    *os.File
+	ln      net.Listener
+	forward func([]string)
+}
+
+// acquireInstance acquires the single instance lock for key. If another
+// instance holds the lock, acquireInstance waits up to wait for it to be
+// released. Failing that, the current invocation's arguments are forwarded
+// to the running instance (if forward is not nil) or an *ExitError with
+// a Code of EX_TEMPFAIL is returned.
+//
+// The lock file, and the unix socket used for forwarding arguments, reside in
+// a directory accessible only to the current user (see instanceDir). Should
+// they have been handed off by our parent process, they are used instead.
+func (a *App) acquireInstance(key string, wait time.Duration, forward func([]string)) (*instance, error) {
+	if inst := inheritedInstance(key, forward); inst != nil {
+		a.RegisterShutdown(inst.close, Phase(Release))
+		return inst, nil
+	}
+
+	dir, err := instanceDir()
+	if err != nil {
+		return nil, NewExitError(EX_CANTCREAT, "single instance %q: %v", key, err)
+	}
+
+	base := filepath.Join(dir, instanceName(key))
+	sock := base + ".sock"
+	deadline := time.Now().Add(wait)
+
+	for {
+		lock, err := os.OpenFile(base+".lock", os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, NewExitError(EX_CANTCREAT, "single instance %q: %v", key, err)
+		}
+
+		err = tryLock(lock)
+		if err == nil {
+			// Any existing socket was left behind by a defunct instance.
+			os.Remove(sock)
+
+			ln, err := net.Listen("unix", sock)
+			if err != nil {
+				lock.Close()
+				return nil, NewExitError(EX_CANTCREAT, "single instance %q: %v", key, err)
+			}
+
+			inst := &instance{key: key, lock: lock, ln: ln, forward: forward}
+			a.RegisterShutdown(inst.close, Phase(Release))
+			return inst, nil
+		}
+
+		lock.Close()
+
+		if err != errWouldBlock {
+			return nil, NewExitError(EX_CANTCREAT, "single instance %q: %v", key, err)
+		}
+
+		if time.Now().After(deadline) {
+			break
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if forward == nil {
+		return nil, NewExitError(EX_TEMPFAIL, "another instance (%q) is already running", key)
+	}
+
+	if err := forwardArgs(sock, a.Args()); err != nil {
+		return nil, NewExitError(EX_TEMPFAIL, "another instance (%q) is already running; forwarding arguments: %v", key, err)
+	}
+
+	log.V(1).Infof("forwarded arguments to running instance %q", key)
+
+	return nil, &ExitError{Code: EX_OK, Err: ErrForwarded}
+}
+
+// serve starts accepting arguments forwarded by subsequent invocations. It is
+// called once the program has been successfully initialized; until then, new
+// connections remain queued.
+func (i *instance) serve() {
+	go func() {
+		for {
+			c, err := i.ln.Accept()
+			if err != nil {
+				return
+			}
+			go i.handle(c)
+		}
+	}()
+}
+
+func (i *instance) handle(c net.Conn) {
+	defer c.Close()
+
+	if i.forward == nil {
+		return
+	}
+
+	c.SetDeadline(time.Now().Add(instanceIOTimeout))
+
+	line, err := bufio.NewReader(c).ReadBytes('\n')
+	if err != nil {
+		log.V(1).Infof("single instance %q: no arguments received: %v", i.key, err)
+		return
+	}
+
+	var args []string
+	if err := json.Unmarshal(line, &args); err != nil {
+		log.Errorf("single instance %q: invalid forwarded arguments: %v", i.key, err)
+		fmt.Fprintln(c, errorMessage, "invalid arguments")
+		return
+	}
+
+	err = callSafely(func() error { i.forward(args); return nil })
+	if pe, ok := err.(*PanicError); ok {
+		log.Errorf("single instance %q: forwarded arguments handler panicked: %v\n%s", i.key, pe.Value, pe.Stack)
+		fmt.Fprintln(c, errorMessage, "handler panicked")
+		return
+	}
+
+	fmt.Fprintln(c, "OK")
+}
+
+// inheritedInstance returns the instance handed off by our parent process,
+// if any.
+func inheritedInstance(key string, forward func([]string)) *instance {
+	fds := os.Getenv(envInstanceFDs)
+	if fds == "" {
+		return nil
+	}
+	os.Unsetenv(envInstanceFDs)
+
+	var lfd, sfd int
+	if _, err := fmt.Sscanf(fds, "%d:%d", &lfd, &sfd); err != nil {
+		log.Errorf("invalid %s=%q", envInstanceFDs, fds)
+		return nil
+	}
+
+	closeOnExec(lfd)
+	closeOnExec(sfd)
+
+	lock := os.NewFile(uintptr(lfd), "instance.lock")
+	sf := os.NewFile(uintptr(sfd), "instance.sock")
+	defer sf.Close()
+
+	if err := tryLock(lock); err != nil {
+		log.Errorf("single instance %q: inherited lock: %v", key, err)
+		lock.Close()
+		return nil
+	}
+
+	ln, err := net.FileListener(sf)
+	if err != nil {
+		log.Errorf("single instance %q: inherited socket: %v", key, err)
+		lock.Close()
+		return nil
+	}
+
+	// N.B. The socket remains our parent's to remove until it commits the
+	// handoff (see takeOver).
+	return &instance{key: key, lock: lock, ln: ln, forward: forward}
+}
+
+// handoffFiles returns the lock file and a duplicate of the socket to be
+// passed to a new process during a graceful restart. The latter should be
+// closed once the new process has been started.
+func (i *instance) handoffFiles() (lock, sock *os.File, err error) {
+	ul, ok := i.ln.(*net.UnixListener)
+	if !ok {
+		return nil, nil, fmt.Errorf("single instance %q: cannot hand off %T", i.key, i.ln)
+	}
+
+	if sock, err = ul.File(); err != nil {
+		return nil, nil, fmt.Errorf("single instance %q: %v", i.key, err)
+	}
+
+	return i.lock, sock, nil
+}
+
+// handedOff is called once a new process has taken over the instance so that
+// closing our listener no longer removes the (now shared) socket.
+func (i *instance) handedOff() {
+	if ul, ok := i.ln.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(false)
+	}
+}
+
+// takeOver is called once our parent process has committed the handoff of
+// an inherited instance; the socket is now ours to remove.
+func (i *instance) takeOver() {
+	if ul, ok := i.ln.(*net.UnixListener); ok {
+		ul.SetUnlinkOnClose(true)
+	}
+}
+
+func (i *instance) close() {
+	if err := i.ln.Close(); err != nil {
+		log.Warningf("single instance %q: %v", i.key, err)
+	}
+	i.lock.Close()
+}
+
+// forwardArgs sends args, as a single line of JSON, to the running instance
+// listening on addr and waits for its reply: either "OK" or "ERROR" followed
+// by an error message.
+func forwardArgs(addr string, args []string) error {
+	c, err := net.DialTimeout("unix", addr, instanceIOTimeout)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	c.SetDeadline(time.Now().Add(instanceIOTimeout))
+
+	b, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.Write(append(b, '\n')); err != nil {
+		return err
+	}
+
+	line, err := bufio.NewReader(c).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("no reply from running instance: %v", err)
+	}
+
+	if line = strings.TrimSpace(line); line != "OK" {
+		return errors.New(strings.TrimSpace(strings.TrimPrefix(line, errorMessage)))
+	}
+
+	return nil
+}
+
+// instanceName returns the base name of the files used for the single
+// instance lock for key.
+func instanceName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:16])
+}
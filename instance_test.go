@@ -0,0 +1,149 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+//go:build !windows
+// +build !windows
+
+package toolman
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// runtimeDir sets XDG_RUNTIME_DIR to a new, private temporary directory.
+func runtimeDir(t *testing.T) func() {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "toolman-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	old, had := os.LookupEnv("XDG_RUNTIME_DIR")
+	os.Setenv("XDG_RUNTIME_DIR", dir)
+
+	return func() {
+		if had {
+			os.Setenv("XDG_RUNTIME_DIR", old)
+		} else {
+			os.Unsetenv("XDG_RUNTIME_DIR")
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func exitCodeOf(err error) int {
+	var ee *ExitError
+	if errors.As(err, &ee) {
+		return ee.Code
+	}
+	return -1
+}
+
+func TestSingleInstance(t *testing.T) {
+	defer runtimeDir(t)()
+
+	first := NewApp("first")
+	if err := first.InitE(CommandArgs(), SingleInstance(t.Name())); err != nil {
+		t.Fatalf("first InitE() failed: %v", err)
+	}
+
+	second := NewApp("second")
+	if err := second.InitE(CommandArgs(), SingleInstance(t.Name())); exitCodeOf(err) != EX_TEMPFAIL {
+		t.Errorf("second InitE() == %v; wanted *ExitError with code %d", err, EX_TEMPFAIL)
+	}
+
+	other := NewApp("other")
+	if err := other.InitE(CommandArgs(), SingleInstance(t.Name()+"-other")); err != nil {
+		t.Errorf("InitE() with another key failed: %v", err)
+	}
+	other.Shutdown()
+
+	first.Shutdown()
+
+	third := NewApp("third")
+	if err := third.InitE(CommandArgs(), SingleInstance(t.Name())); err != nil {
+		t.Errorf("InitE() after first instance shut down failed: %v", err)
+	}
+	third.Shutdown()
+}
+
+func TestWaitInstance(t *testing.T) {
+	defer runtimeDir(t)()
+
+	first := NewApp("first")
+	if err := first.InitE(CommandArgs(), SingleInstance(t.Name())); err != nil {
+		t.Fatalf("first InitE() failed: %v", err)
+	}
+
+	time.AfterFunc(200*time.Millisecond, first.Shutdown)
+
+	second := NewApp("second")
+	if err := second.InitE(CommandArgs(), SingleInstance(t.Name()), WaitInstance(5*time.Second)); err != nil {
+		t.Errorf("second InitE() failed: %v", err)
+	}
+	second.Shutdown()
+}
+
+func TestForwardArgs(t *testing.T) {
+	defer runtimeDir(t)()
+
+	got := make(chan []string, 1)
+
+	first := NewApp("first")
+	if err := first.InitE(CommandArgs(), SingleInstance(t.Name()), ForwardArgs(func(args []string) { got <- args })); err != nil {
+		t.Fatalf("first InitE() failed: %v", err)
+	}
+	defer first.Shutdown()
+
+	second := NewApp("second")
+	err := second.InitE(CommandArgs("a", "b"), SingleInstance(t.Name()), ForwardArgs(func([]string) {}))
+	if !errors.Is(err, ErrForwarded) || exitCodeOf(err) != EX_OK {
+		t.Fatalf("second InitE() == %v; wanted *ExitError wrapping ErrForwarded", err)
+	}
+
+	select {
+	case args := <-got:
+		if want := []string{"a", "b"}; !reflect.DeepEqual(args, want) {
+			t.Errorf("forwarded args == %q; wanted %q", args, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("forwarded args not received")
+	}
+}
+
+func TestInstanceDirInsecure(t *testing.T) {
+	defer runtimeDir(t)()
+
+	if err := os.Mkdir(filepath.Join(os.Getenv("XDG_RUNTIME_DIR"), "toolman"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	a := NewApp(t.Name())
+	if err := a.InitE(CommandArgs(), SingleInstance(t.Name())); exitCodeOf(err) != EX_CANTCREAT {
+		t.Errorf("InitE() == %v; wanted *ExitError with code %d", err, EX_CANTCREAT)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package toolman
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"toolman.org/base/log/v2"
+)
+
+// PanicError is the error reported when a registered InitFunc or
+// ShutdownFunc panics. Value holds the value passed to panic and Stack holds
+// the stack trace of the panicking goroutine.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// callSafely calls f, converting any panic into a *PanicError.
+func callSafely(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Value: r, Stack: debug.Stack()}
+		}
+	}()
+
+	return f()
+}
+
+// Go runs f in a new goroutine. Should f panic, the panic and its stack trace
+// are logged and the program is terminated via Abort with a non-zero exit
+// code, executing all ShutdownFuncs enabled for abort.
+func Go(f func()) {
+	std.Go(f)
+}
+
+// Go runs f in a new goroutine, calling a.Abort should it panic; see the
+// package-level Go for details.
+func (a *App) Go(f func()) {
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				a.abortOnPanic(r, debug.Stack())
+			}
+		}()
+
+		f()
+	}()
+}
+
+func (a *App) catchingPanics() bool {
+	a.downmutex.Lock()
+	defer a.downmutex.Unlock()
+	return a.catchPanics
+}
+
+func (a *App) abortOnPanic(r interface{}, stack []byte) {
+	log.Errorf("panic: %v\n%s", r, stack)
+	log.Flush()
+	a.shutdown(reasonAbort, 1, fmt.Sprintf("panic: %v\n\n%s", r, stack))
+}
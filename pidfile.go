@@ -0,0 +1,268 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package toolman
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"toolman.org/base/log/v2"
+)
+
+// envPIDFileFD names the file descriptor of a locked PID file inherited from
+// a parent process during a graceful restart (see Restart).
+const envPIDFileFD = "TOOLMAN_PIDFILE_FD"
+
+// A pidFile is an exclusively locked PID file. The lock is held (via flock)
+// for as long as file remains open, i.e. for the lifetime of the process.
+type pidFile struct {
+	mu    sync.Mutex
+	path  string
+	file  *os.File
+	taken bool // taken over by a new process (see Restart)
+}
+
+// lockPIDFile atomically writes the current process ID to the file at path
+// and acquires an exclusive lock on it. If another live process already
+// holds the lock, an *ExitError carrying EX_TEMPFAIL is returned. If
+// inherited is not nil, it is a descriptor for the existing (and already
+// locked) PID file passed to us by our parent process.
+func lockPIDFile(path string, inherited *os.File) (*pidFile, error) {
+	for tries := 0; ; tries++ {
+		old, err := lockExisting(path, inherited)
+		if err != nil {
+			return nil, err
+		}
+
+		if old == nil {
+			// The file was replaced after we opened it (and lockExisting has
+			// closed it, inherited or not); try again.
+			if tries >= 5 {
+				return nil, NewExitError(EX_TEMPFAIL, "pid file %q: unable to acquire stable lock", path)
+			}
+			inherited = nil
+			continue
+		}
+
+		pf, err := replacePIDFile(path, old)
+		old.Close()
+
+		return pf, err
+	}
+}
+
+// lockExisting opens (creating if necessary) and locks the current file at
+// path. If, once locked, the file is no longer the one at path (because a
+// competing process has since replaced it), nil is returned.
+func lockExisting(path string, inherited *os.File) (*os.File, error) {
+	f := inherited
+	if f == nil {
+		var err error
+		if f, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644); err != nil {
+			return nil, fmt.Errorf("opening pid file: %v", err)
+		}
+	}
+
+	if err := tryLock(f); err != nil {
+		pid, _ := readPID(f)
+		f.Close()
+		if err == errWouldBlock {
+			return nil, NewExitError(EX_TEMPFAIL, "pid file %q is locked by another running process (pid %d)", path, pid)
+		}
+		return nil, fmt.Errorf("locking pid file %q: %v", path, err)
+	}
+
+	ofi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if nfi, err := os.Stat(path); err != nil || !os.SameFile(ofi, nfi) {
+		f.Close()
+		return nil, nil
+	}
+
+	if pid, ok := readPID(f); ok && pid != os.Getpid() && inherited == nil {
+		if processExists(pid) {
+			log.Warningf("pid file %q names running process %d which does not hold its lock; assuming it is stale", path, pid)
+		} else {
+			log.V(1).Infof("replacing stale pid file %q for process %d", path, pid)
+		}
+	}
+
+	return f, nil
+}
+
+// replacePIDFile writes the current process ID to a new, locked temporary file
+// and renames it over path. Since old is locked (and remains so until after
+// the rename), no competing process can replace path in the meantime.
+func replacePIDFile(path string, old *os.File) (*pidFile, error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return nil, fmt.Errorf("writing pid file: %v", err)
+	}
+
+	fail := func(err error) (*pidFile, error) {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, fmt.Errorf("writing pid file %q: %v", path, err)
+	}
+
+	if err := tryLock(tmp); err != nil {
+		return fail(err)
+	}
+
+	if _, err := tmp.WriteString(strconv.Itoa(os.Getpid()) + "\n"); err != nil {
+		return fail(err)
+	}
+
+	if err := tmp.Chmod(0644); err != nil {
+		return fail(err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		return fail(err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fail(err)
+	}
+
+	return &pidFile{path: path, file: tmp}, nil
+}
+
+// adoptPIDFile returns a pidFile for the locked PID file inherited from our
+// parent process during a graceful restart. The file, which still names our
+// parent, is left untouched until takeOver is called.
+func adoptPIDFile(path string, inherited *os.File) (*pidFile, error) {
+	if err := tryLock(inherited); err != nil {
+		inherited.Close()
+		return nil, fmt.Errorf("locking inherited pid file %q: %v", path, err)
+	}
+
+	return &pidFile{path: path, file: inherited}, nil
+}
+
+// takeOver replaces the contents of a PID file adopted from our parent
+// process, ppid, with our own PID.
+//
+// Should this fail, the inherited descriptor (and with it, our lock) is gone.
+// Since our parent has already relinquished the PID file, it is removed
+// rather than left naming our parent, unless another process has since
+// claimed it.
+func (pf *pidFile) takeOver(ppid int) error {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+
+	npf, err := lockPIDFile(pf.path, pf.file)
+	if err != nil {
+		pf.file = nil
+
+		if f, oerr := os.Open(pf.path); oerr == nil {
+			pid, ok := readPID(f)
+			f.Close()
+			if ok && pid == ppid {
+				os.Remove(pf.path)
+			}
+		}
+
+		return err
+	}
+
+	pf.file = npf.file
+
+	return nil
+}
+
+// handedOff is called once a new process has taken over the PID file so
+// that remove merely releases our lock.
+func (pf *pidFile) handedOff() {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+	pf.taken = true
+}
+
+// remove removes the PID file, but only if it still contains our own PID
+// (i.e. it hasn't since been taken over by another process), and then
+// releases its lock. If the lock was lost (see takeOver), remove does
+// nothing.
+func (pf *pidFile) remove() {
+	pf.mu.Lock()
+	defer pf.mu.Unlock()
+
+	if pf.file == nil {
+		return
+	}
+	defer pf.file.Close()
+
+	if pf.taken {
+		return
+	}
+
+	f, err := os.Open(pf.path)
+	if err != nil {
+		log.Warningf("pidfile %q not removed on shutdown: %v", pf.path, err)
+		return
+	}
+	pid, ok := readPID(f)
+	f.Close()
+
+	if !ok || pid != os.Getpid() {
+		log.V(1).Infof("pidfile %q now belongs to process %d; not removing", pf.path, pid)
+		return
+	}
+
+	if err := os.Remove(pf.path); err != nil {
+		log.Warningf("pidfile %q not removed on shutdown: %v", pf.path, err)
+	}
+}
+
+func readPID(f *os.File) (int, bool) {
+	buf := make([]byte, 32)
+	n, _ := f.ReadAt(buf, 0)
+	pid, err := strconv.Atoi(string(bytes.TrimSpace(buf[:n])))
+	return pid, err == nil && pid > 0
+}
+
+// inheritedPIDFile returns the locked PID file descriptor passed to us by
+// our parent process, if any.
+func inheritedPIDFile() *os.File {
+	fds := os.Getenv(envPIDFileFD)
+	if fds == "" {
+		return nil
+	}
+	os.Unsetenv(envPIDFileFD)
+
+	fd, err := strconv.Atoi(fds)
+	if err != nil {
+		log.Errorf("invalid %s=%q", envPIDFileFD, fds)
+		return nil
+	}
+
+	closeOnExec(fd)
+	return os.NewFile(uintptr(fd), "pidfile")
+}
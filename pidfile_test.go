@@ -0,0 +1,287 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+//go:build !windows
+// +build !windows
+
+package toolman
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func tempPIDFile(t *testing.T) (string, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "toolman-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return filepath.Join(dir, "test.pid"), func() { os.RemoveAll(dir) }
+}
+
+func checkPIDFile(t *testing.T, path string, want int) {
+	t.Helper()
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := strings.TrimSpace(string(data)); got != strconv.Itoa(want) {
+		t.Errorf("pid file contains %q; wanted %d", got, want)
+	}
+}
+
+func TestLockPIDFile(t *testing.T) {
+	path, cleanup := tempPIDFile(t)
+	defer cleanup()
+
+	pf, err := lockPIDFile(path, nil)
+	if err != nil {
+		t.Fatalf("lockPIDFile() failed: %v", err)
+	}
+
+	checkPIDFile(t, path, os.Getpid())
+
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0644 {
+		t.Errorf("pid file stat: (%v, %v); wanted mode 0644", fi.Mode(), err)
+	}
+
+	// A second lock on the same file must fail, even from the same process.
+	_, err = lockPIDFile(path, nil)
+
+	var ee *ExitError
+	if !errors.As(err, &ee) || ee.Code != EX_TEMPFAIL {
+		t.Errorf("second lockPIDFile() == %v; wanted *ExitError with code %d", err, EX_TEMPFAIL)
+	}
+
+	pf.remove()
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("pid file not removed: %v", err)
+	}
+
+	// ...and now that it has been released, it may be locked again.
+	pf, err = lockPIDFile(path, nil)
+	if err != nil {
+		t.Fatalf("lockPIDFile() after remove failed: %v", err)
+	}
+	pf.remove()
+}
+
+func TestLockPIDFileStale(t *testing.T) {
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		content string
+	}{
+		{"empty", ""},
+		{"garbage", "not a pid\n"},
+		{"dead-process", strconv.Itoa(cmd.ProcessState.Pid()) + "\n"},
+		{"unlocked-live-process", strconv.Itoa(os.Getppid()) + "\n"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path, cleanup := tempPIDFile(t)
+			defer cleanup()
+
+			if err := ioutil.WriteFile(path, []byte(tc.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			pf, err := lockPIDFile(path, nil)
+			if err != nil {
+				t.Fatalf("lockPIDFile() failed: %v", err)
+			}
+			defer pf.remove()
+
+			checkPIDFile(t, path, os.Getpid())
+		})
+	}
+}
+
+func TestLockPIDFileInherited(t *testing.T) {
+	path, cleanup := tempPIDFile(t)
+	defer cleanup()
+
+	parent, err := lockPIDFile(path, nil)
+	if err != nil {
+		t.Fatalf("lockPIDFile() failed: %v", err)
+	}
+	defer parent.file.Close()
+
+	// Simulate the descriptor inherited by a restarted process.
+	fd, err := syscall.Dup(int(parent.file.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child, err := lockPIDFile(path, os.NewFile(uintptr(fd), "inherited"))
+	if err != nil {
+		t.Fatalf("lockPIDFile(inherited) failed: %v", err)
+	}
+	defer child.remove()
+
+	checkPIDFile(t, path, os.Getpid())
+
+	if _, err := lockPIDFile(path, nil); err == nil {
+		t.Error("lockPIDFile() succeeded while held by inheriting process")
+	}
+}
+
+func TestPIDFileTakeOverFailure(t *testing.T) {
+	cases := []struct {
+		name    string
+		fail    func(t *testing.T, path string, inherited *os.File) (release func())
+		removed bool
+	}{
+		{
+			// The inherited descriptor is unusable; the pid file still
+			// names our (former) parent and must not be left behind.
+			name: "stale",
+			fail: func(_ *testing.T, _ string, inherited *os.File) func() {
+				inherited.Close()
+				return func() {}
+			},
+			removed: true,
+		},
+		{
+			// Another process has since replaced (and locked) the pid file.
+			name: "claimed",
+			fail: func(t *testing.T, path string, _ *os.File) func() {
+				other, err := os.OpenFile(path+".other", os.O_RDWR|os.O_CREATE, 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if err := tryLock(other); err != nil {
+					other.Close()
+					t.Fatal(err)
+				}
+
+				if _, err := other.WriteString(strconv.Itoa(os.Getppid()) + "\n"); err != nil {
+					t.Fatal(err)
+				}
+
+				if err := os.Rename(other.Name(), path); err != nil {
+					other.Close()
+					t.Fatal(err)
+				}
+
+				return func() { other.Close() }
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path, cleanup := tempPIDFile(t)
+			defer cleanup()
+
+			parent, err := lockPIDFile(path, nil)
+			if err != nil {
+				t.Fatalf("lockPIDFile() failed: %v", err)
+			}
+			defer parent.file.Close()
+
+			// Simulate the descriptor inherited by a restarted process.
+			fd, err := syscall.Dup(int(parent.file.Fd()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			child, err := adoptPIDFile(path, os.NewFile(uintptr(fd), "inherited"))
+			if err != nil {
+				t.Fatalf("adoptPIDFile() failed: %v", err)
+			}
+
+			parent.handedOff()
+			defer tc.fail(t, path, child.file)()
+
+			if err := child.takeOver(os.Getpid()); err == nil {
+				t.Fatal("takeOver() succeeded; wanted failure")
+			}
+
+			if child.file != nil {
+				t.Error("takeOver() failure retained the inherited file")
+			}
+
+			// Neither must remove the pid file (or close a file twice).
+			child.remove()
+			parent.remove()
+
+			if _, err := os.Stat(path); os.IsNotExist(err) != tc.removed {
+				t.Errorf("pid file exists: %v; wanted removed: %t", err, tc.removed)
+			}
+
+			if !tc.removed {
+				checkPIDFile(t, path, os.Getppid())
+			}
+		})
+	}
+}
+
+func TestPIDFileRemoveOther(t *testing.T) {
+	path, cleanup := tempPIDFile(t)
+	defer cleanup()
+
+	pf, err := lockPIDFile(path, nil)
+	if err != nil {
+		t.Fatalf("lockPIDFile() failed: %v", err)
+	}
+
+	// Another process has since taken over the pid file.
+	other := os.Getppid()
+	if err := ioutil.WriteFile(path, []byte(strconv.Itoa(other)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	pf.remove()
+
+	checkPIDFile(t, path, other)
+}
+
+func TestWritePIDFileFailure(t *testing.T) {
+	path, cleanup := tempPIDFile(t)
+	defer cleanup()
+
+	a := NewApp(t.Name())
+	err := a.writePIDFile(filepath.Join(path, "missing", "test.pid"))
+
+	var ee *ExitError
+	if !errors.As(err, &ee) || ee.Code != EX_CANTCREAT {
+		t.Errorf("writePIDFile() == %v; wanted *ExitError with code %d", err, EX_CANTCREAT)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package toolman
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"toolman.org/base/log/v2"
+)
+
+// A child process started by toolman (see Restart and Daemonize) reports the
+// outcome of its Init to its parent over a pipe whose file descriptor is
+// given by the TOOLMAN_READY_FD environment variable. A single line is
+// written: either "READY" or "ERROR" followed by the exit code with which the
+// child will terminate and an error message.
+const (
+	envReadyFD   = "TOOLMAN_READY_FD"
+	readyMessage = "READY"
+	errorMessage = "ERROR"
+)
+
+// notifyParent tells the parent process, if any, whether the current process
+// has successfully initialized; err is nil on success. Otherwise, the exit
+// code requested by err (see Abort) is reported along with its message.
+func notifyParent(err error) {
+	fds := os.Getenv(envReadyFD)
+	if fds == "" {
+		return
+	}
+	os.Unsetenv(envReadyFD)
+
+	fd, perr := strconv.Atoi(fds)
+	if perr != nil {
+		log.Errorf("invalid %s=%q", envReadyFD, fds)
+		return
+	}
+
+	f := os.NewFile(uintptr(fd), "ready")
+	defer f.Close()
+
+	msg := readyMessage
+	if err != nil {
+		msg = fmt.Sprintf("%s %d %s", errorMessage, exitCode(err, 1), strings.Replace(err.Error(), "\n", " ", -1))
+	}
+
+	if _, err := fmt.Fprintln(f, msg); err != nil {
+		log.Errorf("notifying parent of readiness: %v", err)
+	}
+}
+
+// waitReady waits for a child process to report its readiness on r. An
+// error is returned if the child reports a failure, if a value is received
+// from exited before the child is ready or, if timeout is non-zero, if the
+// timeout expires first. A failure reported by the child is returned as an
+// *ExitError carrying the child's exit code.
+func waitReady(r *os.File, exited <-chan error, timeout time.Duration) error {
+	result := make(chan error, 1)
+	go func() {
+		line, _ := bufio.NewReader(r).ReadString('\n')
+		line = strings.TrimSpace(line)
+
+		switch {
+		case line == readyMessage:
+			result <- nil
+		case strings.HasPrefix(line, errorMessage):
+			result <- parseChildError(strings.TrimPrefix(line, errorMessage))
+		default:
+			result <- errors.New("failed to initialize")
+		}
+	}()
+
+	var tout <-chan time.Time
+	if timeout > 0 {
+		tout = time.After(timeout)
+	}
+
+	select {
+	case err := <-result:
+		return err
+
+	case err := <-exited:
+		// Prefer any failure reported by the child before it exited
+		select {
+		case rerr := <-result:
+			if rerr != nil {
+				return rerr
+			}
+		case <-time.After(time.Second):
+		}
+		return fmt.Errorf("exited before becoming ready: %v", err)
+
+	case <-tout:
+		return fmt.Errorf("not ready after %v", timeout)
+	}
+}
+
+// parseChildError returns the *ExitError described by the remainder of an
+// "ERROR" line written by notifyParent. A child exiting with EX_OK (e.g. one
+// that forwarded its arguments to an already running instance) has not
+// failed; its error merely describes why it is not becoming ready.
+func parseChildError(s string) error {
+	f := strings.SplitN(strings.TrimSpace(s), " ", 2)
+
+	code, err := strconv.Atoi(f[0])
+	if err != nil {
+		return fmt.Errorf("failed to initialize: %s", strings.TrimSpace(s))
+	}
+
+	var msg string
+	if len(f) > 1 {
+		msg = f[1]
+	}
+
+	if code == EX_OK {
+		return &ExitError{Code: code, Err: errors.New(msg)}
+	}
+
+	return NewExitError(code, "failed to initialize: %s", msg)
+}
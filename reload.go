@@ -0,0 +1,105 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package toolman
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"toolman.org/base/log/v2"
+	"toolman.org/base/runtimeutil"
+	"toolman.org/base/signals"
+)
+
+// ReloadFunc is a function registered via RegisterReload. Reload functions
+// typically re-read configuration files or reopen output files.
+type ReloadFunc func() error
+
+type reloadAction struct {
+	id       string
+	reloadFn ReloadFunc
+}
+
+// RegisterReload registers a ReloadFunc to be executed whenever the program
+// is asked to reload, such as on receipt of SIGHUP when the ReloadOnHUP
+// InitOption has been provided to Init. ReloadFuncs are executed serially in
+// registration order; errors returned (and panics raised) by each are logged
+// but do not prevent the remaining ReloadFuncs from being executed.
+func RegisterReload(f ReloadFunc) {
+	std.RegisterReload(f)
+}
+
+// RegisterReload registers a ReloadFunc to be executed whenever a is asked
+// to reload; see the package-level RegisterReload for details.
+func (a *App) RegisterReload(f ReloadFunc) {
+	a.reloadmutex.Lock()
+	defer a.reloadmutex.Unlock()
+
+	a.reloadfuncs = append(a.reloadfuncs, &reloadAction{id: runtimeutil.FuncID(f), reloadFn: f})
+}
+
+func (a *App) setupReloadSignal() {
+	signals.RegisterHandler(func(sig os.Signal) bool {
+		log.Infof("received %v; reloading", sig)
+		go a.reload()
+		return true
+	}, syscall.SIGHUP)
+}
+
+// reload flushes all pending log output, reopens the daemon's output file (if
+// any) and then executes each registered ReloadFunc, logging its outcome.
+// Flags and log files are not reloaded (see ReloadOnHUP). Concurrent reloads
+// are serialized.
+func (a *App) reload() {
+	a.reloadmutex.Lock()
+	defer a.reloadmutex.Unlock()
+
+	log.Flush()
+
+	if err := a.reopenOutput(); err != nil {
+		log.Errorf("reopening daemon output: %v", err)
+	}
+
+	var failed int
+	for _, ra := range a.reloadfuncs {
+		start := time.Now()
+		err := callSafely(ra.reloadFn)
+
+		switch e := err.(type) {
+		case nil:
+			log.V(1).Infof("reload func %s completed in %v", ra.id, time.Since(start))
+			continue
+		case *PanicError:
+			log.Errorf("reload func %s panicked: %v\n%s", ra.id, e.Value, e.Stack)
+		default:
+			log.Errorf("reload func %s failed: %v", ra.id, err)
+		}
+
+		failed++
+	}
+
+	if failed != 0 {
+		log.Warningf("reload completed with %d of %d reload func(s) failing", failed, len(a.reloadfuncs))
+	} else {
+		log.Infof("reload completed successfully (%d reload funcs)", len(a.reloadfuncs))
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+//go:build !windows
+// +build !windows
+
+package toolman
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	var order []string
+
+	a := NewApp(t.Name())
+	a.RegisterReload(func() error { order = append(order, "a"); return nil })
+	a.RegisterReload(func() error { order = append(order, "b"); return errors.New("failed") })
+	a.RegisterReload(func() error { order = append(order, "c"); panic("boom") })
+	a.RegisterReload(func() error { order = append(order, "d"); return nil })
+
+	a.reload()
+
+	if want := []string{"a", "b", "c", "d"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("ReloadFuncs executed in order %v; wanted %v", order, want)
+	}
+}
+
+func TestReloadReopensOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "toolman-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Preserve the test's own STDOUT and STDERR
+	for _, fd := range []int{1, 2} {
+		saved, err := syscall.Dup(fd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func(fd int) {
+			dupFD(saved, fd)
+			syscall.Close(saved)
+		}(fd)
+	}
+
+	path := filepath.Join(dir, "test.out")
+	rotated := path + ".1"
+
+	a := NewApp(t.Name())
+	a.daemonOut = path
+
+	a.reload()
+	os.Stdout.WriteString("before\n")
+
+	// Simulate logrotate
+	if err := os.Rename(path, rotated); err != nil {
+		t.Fatal(err)
+	}
+
+	a.reload()
+	os.Stderr.WriteString("after\n")
+
+	for p, want := range map[string]string{rotated: "before\n", path: "after\n"} {
+		if got, err := ioutil.ReadFile(p); err != nil || string(got) != want {
+			t.Errorf("%s contains (%q, %v); wanted %q", filepath.Base(p), got, err, want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package toolman
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"time"
+
+	"toolman.org/base/log/v2"
+)
+
+// ShutdownReport describes the outcome of a program's termination via
+// Shutdown or Abort. It is logged just prior to exit and may also be written
+// to a file using the ShutdownReportFile InitOption. All durations are
+// reported in nanoseconds when encoded as JSON.
+type ShutdownReport struct {
+	Reason   string          `json:"reason"`
+	ExitCode int             `json:"exit_code"`
+	Start    time.Time       `json:"start"`
+	Duration time.Duration   `json:"duration"`
+	Actions  []*ActionReport `json:"actions"`
+}
+
+// ActionReport describes the outcome of a single ShutdownFunc. Actions that
+// were not applicable for the current termination (see OnShutdown, OnAbort
+// and OnSignal) are reported as Skipped while those that were abandoned after
+// exceeding their TimeAllowance are reported as TimedOut.
+type ActionReport struct {
+	ID       string        `json:"id"`
+	Phase    ShutdownPhase `json:"phase"`
+	Skipped  bool          `json:"skipped,omitempty"`
+	TimedOut bool          `json:"timed_out,omitempty"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+	Panic    string        `json:"panic,omitempty"`
+}
+
+func (ar *ActionReport) outcome() string {
+	switch {
+	case ar.Skipped:
+		return "skipped"
+	case ar.TimedOut:
+		return "timed out"
+	case ar.Panic != "":
+		return "panicked: " + ar.Panic
+	case ar.Error != "":
+		return "failed: " + ar.Error
+	default:
+		return "ok"
+	}
+}
+
+func (r *ShutdownReport) panicked() bool {
+	for _, ar := range r.Actions {
+		if ar.Panic != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *ShutdownReport) log() {
+	var failed int
+	for _, ar := range r.Actions {
+		if ar.TimedOut || ar.Error != "" {
+			failed++
+		}
+	}
+
+	log.Infof("shutdown report: reason=%s code=%d actions=%d failed=%d duration=%v", r.Reason, r.ExitCode, len(r.Actions), failed, r.Duration)
+
+	for _, ar := range r.Actions {
+		log.Infof("  [phase %d] %s: %v (%s)", ar.Phase, ar.ID, ar.Duration, ar.outcome())
+	}
+}
+
+func (r *ShutdownReport) writeFile(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
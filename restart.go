@@ -0,0 +1,327 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package toolman
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"toolman.org/base/log/v2"
+	"toolman.org/base/signals"
+)
+
+// Once the new process started by Restart is ready, its parent commits the
+// handoff by writing "COMMIT" to a pipe whose file descriptor is given by the
+// TOOLMAN_HANDOFF_FD environment variable. Until then, the new process must
+// leave the resources it inherited (i.e. the PID file and single instance
+// socket) as they are, so that its parent may carry on using them should the
+// new process fail.
+const (
+	envHandoffFD  = "TOOLMAN_HANDOFF_FD"
+	commitMessage = "COMMIT"
+)
+
+// A handoff is a graceful restart, begun by our parent process, that has yet
+// to be committed.
+type handoff struct {
+	commit *os.File // read end of the commit pipe
+	ppid   int
+}
+
+// Restart performs a graceful restart of the current program. The program's
+// executable is re-executed, with the same command line arguments, and passed
+// all Listeners obtained via Listen, RegisterListener or socket activation as
+// inherited file descriptors. Restart then waits for the new process to
+// signal that it has successfully completed Init, at which point the current
+// process is shut down (as if by Shutdown) so that in-flight requests may be
+// drained. Note that, unless the new process fails to become ready, Restart
+// does not return.
+//
+// If a PID file is in use (see PIDFile), its lock is handed off to the new
+// process which, once the handoff is complete, replaces its contents with its
+// own PID; the current process will not remove it during shutdown.
+//
+// Likewise, the lock (and socket) held by a program using SingleInstance is
+// handed off to the new process.
+//
+// If the program was started as a daemon (see Daemonize), the new process
+// remains detached and does not daemonize again.
+//
+// When using SystemdNotify, systemd is told that the new process is the
+// service's new main process (i.e. MAINPID) and the current process does not
+// send STOPPING=1 as it shuts down. The new process takes over any watchdog
+// (see WATCHDOG_PID) and sends READY=1 only once the handoff is complete.
+//
+// If the new process exits, or fails to become ready within the timeout
+// given to GracefulRestart (30s by default), it is killed and an error is
+// returned; the current process continues running.
+func Restart() error {
+	return std.restart()
+}
+
+func (a *App) setupRestartSignal(sig os.Signal) {
+	signals.RegisterHandler(func(sig os.Signal) bool {
+		log.Infof("received %v; restarting", sig)
+		go func() {
+			if err := a.restart(); err != nil {
+				log.Errorf("graceful restart failed: %v", err)
+			}
+		}()
+		return true
+	}, sig)
+}
+
+func (a *App) restart() error {
+	a.restartmutex.Lock()
+	defer a.restartmutex.Unlock()
+
+	if a.ctx.Err() != nil {
+		return errors.New("cannot restart while shutting down")
+	}
+
+	a.runmutex.Lock()
+	pending := a.handoff != nil
+	a.runmutex.Unlock()
+
+	if pending {
+		return errors.New("cannot restart before taking over from our parent process")
+	}
+
+	a.runmutex.Lock()
+	timeout := a.restartTimeout
+	a.runmutex.Unlock()
+
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	files, names, dups, err := a.handoffFiles()
+	if err != nil {
+		return err
+	}
+	defer closeFiles(dups)
+
+	a.runmutex.Lock()
+	inst := a.instance
+	a.runmutex.Unlock()
+
+	var instFiles []*os.File
+	if inst != nil {
+		lock, sock, err := inst.handoffFiles()
+		if err != nil {
+			return err
+		}
+		defer sock.Close()
+		instFiles = []*os.File{lock, sock}
+	}
+
+	rp, wp, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer rp.Close()
+
+	cr, cw, err := os.Pipe()
+	if err != nil {
+		wp.Close()
+		return err
+	}
+	defer cw.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, wp, cr)
+	cmd.Env = append(os.Environ(),
+		"LISTEN_FDS="+strconv.Itoa(len(files)),
+		"LISTEN_FDNAMES="+strings.Join(names, ":"),
+		envListenPPID+"="+strconv.Itoa(os.Getpid()),
+		envReadyFD+"="+strconv.Itoa(listenFdsStart+len(files)),
+		envHandoffFD+"="+strconv.Itoa(listenFdsStart+len(files)+1),
+	)
+
+	// A daemon's replacement is already detached; it must not daemonize again
+	a.outmutex.Lock()
+	if a.daemonOut != "" {
+		cmd.Env = append(cmd.Env, envDaemon+"="+a.daemonOut)
+	}
+	a.outmutex.Unlock()
+
+	// Hand off our locked PID file so the new process can take it over
+	a.runmutex.Lock()
+	pf := a.pidFile
+	a.runmutex.Unlock()
+
+	if pf != nil {
+		cmd.Env = append(cmd.Env, envPIDFileFD+"="+strconv.Itoa(listenFdsStart+len(cmd.ExtraFiles)))
+		cmd.ExtraFiles = append(cmd.ExtraFiles, pf.file)
+	}
+
+	// ...and likewise our single instance lock
+	if instFiles != nil {
+		n := listenFdsStart + len(cmd.ExtraFiles)
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%d:%d", envInstanceFDs, n, n+1))
+		cmd.ExtraFiles = append(cmd.ExtraFiles, instFiles...)
+	}
+
+	err = cmd.Start()
+	wp.Close()
+	cr.Close()
+	if err != nil {
+		return fmt.Errorf("starting new process: %v", err)
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	if err := waitReady(rp, exited, timeout); err != nil {
+		cmd.Process.Kill()
+		return fmt.Errorf("new process (pid %d) %v", cmd.Process.Pid, err)
+	}
+
+	log.Infof("new process (pid %d) is ready; shutting down", cmd.Process.Pid)
+
+	// Commit the handoff; from here on, our listeners, the PID file and the
+	// single instance socket belong to the new process.
+	a.sockmutex.Lock()
+	for _, as := range a.sockets {
+		as.handedOff()
+	}
+	a.sockmutex.Unlock()
+
+	if pf != nil {
+		pf.handedOff()
+	}
+
+	if inst != nil {
+		inst.handedOff()
+	}
+
+	a.sdHandOff(cmd.Process.Pid)
+
+	if _, err := fmt.Fprintln(cw, commitMessage); err != nil {
+		log.Errorf("committing handoff to new process (pid %d): %v", cmd.Process.Pid, err)
+	}
+	cw.Close()
+
+	a.shutdown(reasonShutdown, 0, "")
+
+	return nil
+}
+
+// inheritedHandoff returns the uncommitted handoff begun by our parent
+// process, if any.
+func inheritedHandoff() *handoff {
+	fds := os.Getenv(envHandoffFD)
+	if fds == "" {
+		return nil
+	}
+	os.Unsetenv(envHandoffFD)
+
+	fd, err := strconv.Atoi(fds)
+	if err != nil {
+		log.Errorf("invalid %s=%q", envHandoffFD, fds)
+		return nil
+	}
+
+	closeOnExec(fd)
+
+	return &handoff{commit: os.NewFile(uintptr(fd), "handoff"), ppid: os.Getppid()}
+}
+
+// awaitHandoff waits for our parent process to commit the handoff h and then
+// takes over the PID file and single instance socket inherited from it. If sd
+// is true, we then also take over as the service's main process under
+// systemd.
+func (a *App) awaitHandoff(h *handoff, sd bool) {
+	line, _ := bufio.NewReader(h.commit).ReadString('\n')
+	h.commit.Close()
+
+	if strings.TrimSpace(line) != commitMessage {
+		log.Warningf("handoff from parent process %d was not committed", h.ppid)
+		return
+	}
+
+	a.runmutex.Lock()
+	a.handoff = nil
+	pf, inst := a.pidFile, a.instance
+	a.runmutex.Unlock()
+
+	if pf != nil {
+		if err := pf.takeOver(h.ppid); err != nil {
+			log.Errorf("taking over pid file: %v", err)
+		}
+	}
+
+	if inst != nil {
+		inst.takeOver()
+	}
+
+	if sd {
+		a.sdTakeOver(h.ppid)
+	}
+
+	log.V(1).Infof("took over from parent process %d", h.ppid)
+}
+
+// handoffFiles returns the files (and their names) for all Listeners to be
+// handed off to a new process. Also returned are those files that were
+// duplicated for the handoff and should be closed once the new process has
+// been started.
+func (a *App) handoffFiles() (files []*os.File, names []string, dups []*os.File, err error) {
+	a.sockmutex.Lock()
+	defer a.sockmutex.Unlock()
+
+	for _, as := range a.sockets {
+		f, err := as.handoffFile()
+		if err != nil {
+			closeFiles(dups)
+			return nil, nil, nil, fmt.Errorf("listener %q: %v", as.name, err)
+		}
+
+		if f != as.file {
+			dups = append(dups, f)
+		}
+
+		files = append(files, f)
+		names = append(names, as.name)
+	}
+
+	return files, names, dups, nil
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
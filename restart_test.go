@@ -0,0 +1,254 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+//go:build !windows
+// +build !windows
+
+package toolman
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+const (
+	envRestartChild   = "TOOLMAN_TEST_RESTART_CHILD"
+	envRestartPIDFile = "TOOLMAN_TEST_RESTART_PIDFILE"
+)
+
+// restartReport is written on STDOUT by a process run by restartChild for
+// the parent test to collect (see TestRestart).
+func restartReport(format string, args ...interface{}) {
+	fmt.Printf("restart test: "+format+"\n", args...)
+}
+
+// restartChild is executed by TestRestart in a child process which then
+// restarts itself, as would the running instance of a program. The original
+// process and its replacement each run restartChild with the same name; the
+// replacement (which alone is passed TOOLMAN_READY_FD) misbehaves according to
+// name.
+func restartChild(name string) {
+	replacement := os.Getenv(envReadyFD) != ""
+
+	pidpath := os.Getenv(envRestartPIDFile)
+
+	dir, err := instanceDir()
+	if err != nil {
+		restartReport("FAIL: %v", err)
+		return
+	}
+	sock := filepath.Join(dir, instanceName("TestRestart")+".sock")
+	lnpath := filepath.Join(filepath.Dir(pidpath), "test.sock")
+	ppid := os.Getppid()
+
+	if !replacement {
+		// As systemd would for the service's main process
+		os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	} else {
+		switch name {
+		case "init-failure":
+			RegisterInitE(func() error { return errors.New("init failed") })
+		case "timeout":
+			RegisterInit(func() { time.Sleep(time.Minute) })
+		}
+	}
+
+	Init(CommandArgs(), Quiet(),
+		PIDFile(pidpath),
+		SingleInstance("TestRestart"),
+		ForwardArgs(func([]string) {}),
+		GracefulRestart(syscall.SIGUSR2, time.Second),
+		SystemdNotify())
+
+	defer Shutdown()
+
+	if _, err := Listen("test", "unix", lnpath); err != nil {
+		restartReport("FAIL: Listen() failed: %v", err)
+	}
+
+	// checkOwner verifies that we hold the PID file (and it names us) and
+	// that our listener and the single instance socket are being served.
+	checkOwner := func() {
+		if data, err := ioutil.ReadFile(pidpath); err != nil || strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+			restartReport("FAIL: pid file contains (%q, %v); wanted %d", data, err, os.Getpid())
+		}
+
+		if _, err := lockPIDFile(pidpath, nil); exitCodeOf(err) != EX_TEMPFAIL {
+			restartReport("FAIL: pid file not locked: %v", err)
+		}
+
+		if err := forwardArgs(sock, []string{"test"}); err != nil {
+			restartReport("FAIL: forwarding arguments: %v", err)
+		}
+
+		if c, err := net.Dial("unix", lnpath); err != nil {
+			restartReport("FAIL: connecting to listener: %v", err)
+		} else {
+			c.Close()
+		}
+	}
+
+	if !replacement {
+		err := Restart()
+		if err == nil {
+			restartReport("FAIL: Restart() returned nil")
+			return
+		}
+
+		checkOwner()
+		restartReport("kept running")
+		return
+	}
+
+	// Wait for our parent to commit the handoff and exit
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if data, _ := ioutil.ReadFile(pidpath); strings.TrimSpace(string(data)) == strconv.Itoa(os.Getpid()) && os.Getppid() != ppid {
+			break
+		}
+	}
+
+	checkOwner()
+
+	if sdWatchdogInterval() == 0 {
+		restartReport("FAIL: systemd watchdog not taken over (WATCHDOG_PID=%s)", os.Getenv("WATCHDOG_PID"))
+	}
+
+	restartReport("took over")
+}
+
+func TestRestart(t *testing.T) {
+	if name := os.Getenv(envRestartChild); name != "" {
+		restartChild(name)
+		return
+	}
+
+	defer runtimeDir(t)()
+
+	// Notifications expected by systemd, other than WATCHDOG=1
+	kept := []string{"READY=1", "STOPPING=1"}
+	replaced := []string{"READY=1", "MAINPID=", "READY=1", "STOPPING=1"}
+
+	cases := []struct {
+		name   string
+		want   string
+		notify []string
+	}{
+		{name: "ready", want: "took over", notify: replaced},
+		{name: "init-failure", want: "kept running", notify: kept},
+		{name: "timeout", want: "kept running", notify: kept},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ns, cleanup := newNotifySocket(t, map[string]string{"WATCHDOG_USEC": "100000"})
+			defer cleanup()
+
+			// Like systemd, keep reading notifications lest their senders
+			// block; all but WATCHDOG=1 are collected.
+			done := make(chan struct{})
+			notified := make(chan []string, 1)
+			go func() {
+				var notify []string
+				for {
+					switch n := ns.next(100 * time.Millisecond); {
+					case n == "":
+						select {
+						case <-done:
+							notified <- notify
+							return
+						default:
+						}
+					case strings.HasPrefix(n, "MAINPID="):
+						notify = append(notify, "MAINPID=")
+					case n != "WATCHDOG=1":
+						notify = append(notify, n)
+					}
+				}
+			}()
+
+			dir, err := ioutil.TempDir("", "toolman-test-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			pidpath := filepath.Join(dir, "test.pid")
+
+			var out bytes.Buffer
+
+			cmd := exec.Command(os.Args[0], "-test.run=^TestRestart$")
+			cmd.Env = append(os.Environ(),
+				envRestartChild+"="+tc.name,
+				envRestartPIDFile+"="+pidpath,
+			)
+			cmd.Stdout = &out
+
+			if err := cmd.Start(); err != nil {
+				t.Fatal(err)
+			}
+
+			timer := time.AfterFunc(30*time.Second, func() { cmd.Process.Kill() })
+			defer timer.Stop()
+
+			// N.B. Wait also waits for the replacement process, which shares
+			// our pipe as its STDOUT.
+			if err := cmd.Wait(); err != nil {
+				t.Errorf("original process failed: %v", err)
+			}
+
+			var found bool
+			for _, line := range strings.Split(out.String(), "\n") {
+				switch msg := strings.TrimPrefix(line, "restart test: "); {
+				case msg == line:
+				case strings.HasPrefix(msg, "FAIL: "):
+					t.Error(strings.TrimPrefix(msg, "FAIL: "))
+				case msg == tc.want:
+					found = true
+				}
+			}
+
+			if !found {
+				t.Errorf("no report %q in output:\n%s", tc.want, out.String())
+			}
+
+			if _, err := os.Stat(pidpath); !os.IsNotExist(err) {
+				t.Errorf("pid file not removed upon exit: %v", err)
+			}
+
+			close(done)
+			notify := <-notified
+			if !reflect.DeepEqual(notify, tc.notify) {
+				t.Errorf("systemd notifications %q; wanted %q", notify, tc.notify)
+			}
+		})
+	}
+}
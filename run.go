@@ -0,0 +1,128 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package toolman
+
+import (
+	"context"
+	"errors"
+	"runtime/debug"
+)
+
+// MainFunc is the signature for the main function passed to Run.
+type MainFunc func(ctx context.Context) error
+
+// Run manages the complete lifecycle of a program. It calls Init with the
+// given InitOptions and then calls main, passing it a Context that is
+// cancelled upon receipt of any signal configured using StandardSignals or
+// ShutdownOn (or when shutdown begins for some other reason; see Context).
+// Once main returns, Run calls Shutdown if main returned nil (or if main
+// returned the Context's error after a signal was received); otherwise, it
+// calls Abort with the returned error (which may be an *ExitError to control
+// the exit code). If Init fails, Run calls Abort with Init's error without
+// calling main; as with Init, a program that has forwarded its arguments to
+// an already running instance (see ForwardArgs) exits successfully instead.
+//
+// Unlike programs that call Init and Shutdown directly, signals do not
+// initiate a shutdown asynchronously; instead, main is expected to return
+// promptly once its Context has been cancelled. ShutdownFuncs registered with
+// OnSignal are executed in this case.
+//
+// A typical use of Run looks like:
+//
+//	func main() {
+//		toolman.Run(func(ctx context.Context) error {
+//			// Do Stuff until ctx is cancelled
+//			return nil
+//		}, toolman.StandardSignals())
+//	}
+func Run(main MainFunc, opts ...*InitOption) {
+	std.Run(main, opts...)
+}
+
+// Run manages the complete lifecycle of a; see the package-level Run for
+// details.
+func (a *App) Run(main MainFunc, opts ...*InitOption) {
+	ctx, cancel := context.WithCancel(a.Context())
+	defer cancel()
+
+	a.runmutex.Lock()
+	a.runCancel = cancel
+	a.runmutex.Unlock()
+
+	if err := a.InitE(opts...); err != nil {
+		if errors.Is(err, ErrForwarded) {
+			a.Exit(EX_OK, nil)
+		} else {
+			a.Abort(err)
+		}
+		return
+	}
+
+	err := a.callMain(ctx, main)
+
+	signaled := a.wasSignaled()
+
+	switch {
+	case signaled && (err == nil || errors.Is(err, context.Canceled)):
+		a.shutdown(reasonSignal, 0, "")
+
+	case err == nil:
+		a.shutdown(reasonShutdown, 0, "")
+
+	default:
+		a.Abort(err)
+	}
+}
+
+func (a *App) callMain(ctx context.Context, main MainFunc) error {
+	if a.catchingPanics() {
+		defer func() {
+			if r := recover(); r != nil {
+				a.abortOnPanic(r, debug.Stack())
+			}
+		}()
+	}
+
+	return main(ctx)
+}
+
+// cancelRun cancels the Context passed to the main function given to Run
+// and returns true. If Run is not managing a's lifecycle, cancelRun does
+// nothing and returns false.
+func (a *App) cancelRun() bool {
+	a.runmutex.Lock()
+	defer a.runmutex.Unlock()
+
+	if a.runCancel == nil {
+		return false
+	}
+
+	a.signaled = true
+	a.runCancel()
+
+	return true
+}
+
+func (a *App) wasSignaled() bool {
+	a.runmutex.Lock()
+	defer a.runmutex.Unlock()
+	return a.signaled
+}
@@ -0,0 +1,130 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package toolman
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	cases := []struct {
+		name    string
+		initErr error
+		main    func(a *App, ctx context.Context) error
+		ran     []string
+		code    int
+		message string
+	}{
+		{
+			name: "return-nil",
+			main: func(*App, context.Context) error { return nil },
+			ran:  []string{"shutdown"},
+		},
+		{
+			name:    "return-error",
+			main:    func(*App, context.Context) error { return errors.New("boom") },
+			ran:     []string{"abort"},
+			code:    1,
+			message: "boom\n",
+		},
+		{
+			name:    "return-exit-error",
+			main:    func(*App, context.Context) error { return NewExitError(EX_CONFIG, "bad config") },
+			ran:     []string{"abort"},
+			code:    EX_CONFIG,
+			message: "bad config\n",
+		},
+		{
+			name: "signal",
+			main: func(a *App, ctx context.Context) error {
+				a.handleShutdownSignal(syscall.SIGTERM)
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			ran: []string{"signal", "shutdown"},
+		},
+		{
+			name: "signal-error",
+			main: func(a *App, ctx context.Context) error {
+				a.handleShutdownSignal(syscall.SIGTERM)
+				<-ctx.Done()
+				return errors.New("interrupted")
+			},
+			ran:     []string{"abort"},
+			code:    1,
+			message: "interrupted\n",
+		},
+		{
+			name:    "init-failure",
+			initErr: errors.New("bad init"),
+			ran:     []string{"abort"},
+			code:    1,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				ran    []string
+				called bool
+				exited []int
+				stderr bytes.Buffer
+			)
+
+			a := NewApp(t.Name())
+			a.setExit(func(code int) { exited = append(exited, code) }, &stderr)
+
+			a.RegisterShutdown(func() { ran = append(ran, "shutdown") }, OnShutdown())
+			a.RegisterShutdown(func() { ran = append(ran, "abort") }, OnAbort())
+			a.RegisterShutdown(func() { ran = append(ran, "signal") }, OnSignal())
+
+			if tc.initErr != nil {
+				a.RegisterInitE(func() error { return tc.initErr })
+			}
+
+			a.Run(func(ctx context.Context) error {
+				called = true
+				return tc.main(a, ctx)
+			}, CommandArgs())
+
+			if called != (tc.initErr == nil) {
+				t.Errorf("main called: %t; wanted %t", called, tc.initErr == nil)
+			}
+
+			if !reflect.DeepEqual(ran, tc.ran) {
+				t.Errorf("executed ShutdownFuncs %v; wanted %v", ran, tc.ran)
+			}
+
+			if want := []int{tc.code}; !reflect.DeepEqual(exited, want) {
+				t.Errorf("exit codes %v; wanted %v", exited, want)
+			}
+
+			if tc.message != "" && stderr.String() != tc.message {
+				t.Errorf("STDERR == %q; wanted %q", stderr.String(), tc.message)
+			}
+		})
+	}
+}
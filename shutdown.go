@@ -21,8 +21,13 @@
 package toolman
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"runtime/debug"
+	"sort"
+	"sync"
 	"time"
 
 	"toolman.org/base/log/v2"
@@ -34,11 +39,73 @@ import (
 // Abort.
 type ShutdownFunc func()
 
+// ShutdownCtxFunc is a context-aware function that is registered for
+// execution upon Shutdown or Abort via RegisterShutdownCtx. The provided
+// Context expires when the function's time allowance has been exhausted.
+type ShutdownCtxFunc func(ctx context.Context) error
+
 type shutdownAction struct {
-	downFunc   ShutdownFunc
+	id         string
+	downFunc   ShutdownCtxFunc
 	allowance  time.Duration
+	phase      ShutdownPhase
+	concurrent bool
 	onAbort    bool
 	onShutdown bool
+	onSignal   bool
+}
+
+// ShutdownPhase defines the sequence in which ShutdownFuncs are executed.
+// During shutdown, phases are executed in ascending order and, within each
+// phase, ShutdownFuncs are executed in reverse registration order. Values
+// other than the predefined constants may be used to interleave additional
+// phases as needed.
+type ShutdownPhase int
+
+// Predefined ShutdownPhases, in the order they are executed. ShutdownFuncs
+// registered without a Phase option are executed during the Cleanup phase.
+const (
+	// StopAccepting is for actions that stop accepting new work, such as
+	// closing server listeners.
+	StopAccepting ShutdownPhase = 100
+
+	// Drain is for actions that wait for in-flight work to complete.
+	Drain ShutdownPhase = 200
+
+	// Cleanup is the default phase for ShutdownFuncs.
+	Cleanup ShutdownPhase = 300
+
+	// Flush is for actions that persist buffered data.
+	Flush ShutdownPhase = 400
+
+	// Release is for actions that release resources such as database pools
+	// or other client connections.
+	Release ShutdownPhase = 500
+
+	// finalPhase is reserved for toolman's own log flushing.
+	finalPhase ShutdownPhase = 1 << 30
+)
+
+// downReason indicates how program termination was initiated.
+type downReason int
+
+const (
+	reasonShutdown downReason = iota
+	reasonAbort
+	reasonSignal
+)
+
+func (r downReason) String() string {
+	switch r {
+	case reasonShutdown:
+		return "shutdown"
+	case reasonAbort:
+		return "abort"
+	case reasonSignal:
+		return "signal"
+	default:
+		return fmt.Sprintf("downReason(%d)", int(r))
+	}
 }
 
 // ShutdownOption is used to modify behavior of ShutdownFuncs registered by
@@ -63,11 +130,50 @@ func OnAbort() ShutdownOption {
 	}
 }
 
+// OnSignal returns a ShutdownOption indicating that the registered
+// ShutdownFunc should only be executed when termination was initiated by
+// receipt of a signal (see StandardSignals and ShutdownOn). Note that a
+// signal-initiated termination is also considered a clean shutdown, so
+// ShutdownFuncs registered with OnShutdown will run in that case as well.
+func OnSignal() ShutdownOption {
+	return func(sa *shutdownAction) {
+		sa.onSignal = true
+	}
+}
+
+// Phase returns a ShutdownOption that assigns the registered ShutdownFunc to
+// the given ShutdownPhase, overriding the default phase of Cleanup. This
+// allows libraries to order their shutdown actions relative to each other
+// regardless of the order in which they were registered.
+func Phase(p ShutdownPhase) ShutdownOption {
+	return func(sa *shutdownAction) {
+		sa.phase = p
+	}
+}
+
+// Concurrent returns a ShutdownOption indicating that the registered
+// ShutdownFunc is independent of all others in its phase and may be executed
+// in parallel with them. All Concurrent ShutdownFuncs in a phase are started
+// as the phase begins while the remaining ShutdownFuncs in the phase are
+// executed serially, in reverse registration order, as usual. A phase is not
+// complete until all of its ShutdownFuncs have finished or been abandoned.
+func Concurrent() ShutdownOption {
+	return func(sa *shutdownAction) {
+		sa.concurrent = true
+	}
+}
+
 // TimeAllowance returns a ShutdownOption that changes the default 100ms
 // allotted for each ShutdownFunc. See Shutdown for details on how these
-// TimeAllowance values are used.
+// TimeAllowance values are used. Since a ShutdownFunc cannot complete in no
+// time at all, a duration that is not positive is ignored (and an error is
+// logged).
 func TimeAllowance(dur time.Duration) ShutdownOption {
 	return func(sa *shutdownAction) {
+		if dur <= 0 {
+			log.Errorf("shutdown func %s: ignoring non-positive time allowance %v", sa.id, dur)
+			return
+		}
 		sa.allowance = dur
 	}
 }
@@ -79,112 +185,340 @@ func TimeAllowance(dur time.Duration) ShutdownOption {
 //
 // Calling RegisterShutdown with no ShutdownOptions provided is equivalent to
 // passing OnShutdown(), OnAbort() and TimeAllowance(100*time.Millisecond). If
-// either OnShutdown or OnAbort is proided then the other will not be enabled
-// unless it too is given.
+// any of OnShutdown, OnAbort or OnSignal is provided then the others will not
+// be enabled unless they too are given.
 func RegisterShutdown(sdf ShutdownFunc, opts ...ShutdownOption) {
-	downmutex.Lock()
-	defer downmutex.Unlock()
+	std.registerShutdown(runtimeutil.FuncID(sdf), func(context.Context) error { sdf(); return nil }, opts)
+}
+
+// RegisterShutdownCtx is similar to RegisterShutdown except that the
+// registered function is passed a Context whose deadline is derived from its
+// TimeAllowance and may return an error. The Context is cancelled when the
+// function's allowance is exhausted, at which point shutdown moves on without
+// it. Errors returned by these functions are logged and collected during
+// shutdown.
+func RegisterShutdownCtx(sdf ShutdownCtxFunc, opts ...ShutdownOption) {
+	std.registerShutdown(runtimeutil.FuncID(sdf), sdf, opts)
+}
+
+// RegisterShutdown registers a ShutdownFunc to be executed when a is shut
+// down; see the package-level RegisterShutdown for details.
+func (a *App) RegisterShutdown(sdf ShutdownFunc, opts ...ShutdownOption) {
+	a.registerShutdown(runtimeutil.FuncID(sdf), func(context.Context) error { sdf(); return nil }, opts)
+}
+
+// RegisterShutdownCtx registers a ShutdownCtxFunc to be executed when a is
+// shut down; see the package-level RegisterShutdownCtx for details.
+func (a *App) RegisterShutdownCtx(sdf ShutdownCtxFunc, opts ...ShutdownOption) {
+	a.registerShutdown(runtimeutil.FuncID(sdf), sdf, opts)
+}
 
-	if finalized {
-		log.ErrorDepth(1, "Cannot register new Shutdown function after calling Shutdown()")
+func (a *App) registerShutdown(id string, sdf ShutdownCtxFunc, opts []ShutdownOption) {
+	a.downmutex.Lock()
+	defer a.downmutex.Unlock()
+
+	if a.finalized {
+		log.ErrorDepth(2, "Cannot register new Shutdown function after calling Shutdown()")
 		return
 	}
 
 	sa := &shutdownAction{
+		id:        id,
 		downFunc:  sdf,
 		allowance: 100 * time.Millisecond,
+		phase:     Cleanup,
 	}
 
 	for _, o := range opts {
 		o(sa)
 	}
 
-	if !sa.onAbort && !sa.onShutdown {
+	if !sa.onAbort && !sa.onShutdown && !sa.onSignal {
 		sa.onAbort = true
 		sa.onShutdown = true
 	}
 
-	downactions = append(downactions, sa)
+	a.downactions = append(a.downactions, sa)
+}
+
+func (a *App) setupShutdown(c *config) {
+	// Until a handoff from our parent is committed, we mustn't notify
+	// systemd on the service's behalf (see sdTakeOver).
+	a.runmutex.Lock()
+	pending := a.handoff != nil
+	a.runmutex.Unlock()
+
+	a.downmutex.Lock()
+	a.reportFile = c.reportFile
+	a.catchPanics = c.catchPanics
+	a.sdNotify = c.sdNotify && a.isStd() && !pending
+	a.downmutex.Unlock()
+
+	a.runmutex.Lock()
+	a.forceCode = c.forceCode
+	a.forceDump = c.forceDump
+	a.restartTimeout = c.restartWait
+	a.runmutex.Unlock()
 }
 
 // Shutdown performs a clean termination of the current program and exits with
 // a return code of 0. Prior to termination, all ShutdownFuncs registered with
-// OnShutdown will be executed in reverse registration order and must complete
-// within an allotted ammount of time.
+// OnShutdown will be executed, phase by phase, in reverse registration order
+// (see ShutdownPhase) and must complete within an allotted ammount of time.
+//
+// By default, each registered ShutdownFunc is given a time allowance of 100ms
+// (see TimeAllowance). Each ShutdownFunc is bounded by its own allowance; one
+// that fails to complete in time is abandoned (and its Context cancelled) so
+// that the remaining ShutdownFuncs may still be executed. The program will
+// terminate once each applicable ShutdownFunc has either completed or been
+// abandoned.
+//
+// A panic in any ShutdownFunc is recovered and logged (along with its stack
+// trace) and the remaining ShutdownFuncs are still executed; however, the
+// program will then exit with a return code of 1.
 //
-// By default, each registered ShutdownFunc is given a time allowance of 100ms.
-// When a shutdown occurs, and before any ShutdownFuncs are executed, a timer
-// is initiated using the sum of all applicable ShutdownFunc time allowances.
-// This is the total ammount of time allowed for shutdown operations; the
-// program will terminate when all ShutdownFuncs have completed OR when this
-// timer expires, whichever comes first.
+// If the CatchPanics InitOption was provided to Init, a deferred call to
+// Shutdown will also recover a panic in the calling goroutine and convert it
+// into a call to Abort (see CatchPanics for details).
 func Shutdown() {
-	shutdown(0, "")
+	if std.catchingPanics() {
+		// N.B. recover must be called directly by the deferred function.
+		if r := recover(); r != nil {
+			std.abortOnPanic(r, debug.Stack())
+		}
+	}
+
+	std.shutdown(reasonShutdown, 0, "")
 }
 
-// Abort terminates the running program and exits with a return code of 1.
-// If err is not nil, its Error() string will be issued on STDERR just prior
-// to termination. Similar to Shutdown, Abort will execute all registered
-// ShutdownFuncs enabled for abort and employs the same time allowance logic
-// as Shutdown.
+// Abort terminates the running program and exits with a return code of 1
+// (or, if err is or wraps an *ExitError, the code it carries). If err is not
+// nil, its Error() string will be issued on STDERR just prior to termination.
+// Similar to Shutdown, Abort will execute all registered ShutdownFuncs enabled
+// for abort and employs the same time allowance logic as Shutdown.
 func Abort(err error) {
+	std.Abort(err)
+}
+
+// ShutdownOn causes Shutdown to be called when the current process receives
+// one of the given signals. ShutdownFuncs registered with OnSignal will also
+// be executed. If the program is using Run, the Context passed to its main
+// function is cancelled instead. Should one of these signals be received once
+// shutdown is already underway, the program is forcibly terminated (see
+// SignalEscalation).
+func ShutdownOn(sigs ...os.Signal) {
+	std.ShutdownOn(sigs...)
+}
+
+// Shutdown executes a's applicable ShutdownFuncs; see the package-level
+// Shutdown for details. Unless a is the default App, Shutdown returns
+// instead of terminating the process.
+func (a *App) Shutdown() {
+	if a.catchingPanics() {
+		// N.B. recover must be called directly by the deferred function.
+		if r := recover(); r != nil {
+			a.abortOnPanic(r, debug.Stack())
+		}
+	}
+
+	a.shutdown(reasonShutdown, 0, "")
+}
+
+// Abort executes a's ShutdownFuncs enabled for abort; see the package-level
+// Abort for details. Unless a is the default App, Abort returns instead of
+// terminating the process.
+func (a *App) Abort(err error) {
 	var msg string
 	if err != nil {
 		msg = err.Error()
 	}
-	shutdown(1, msg)
+	a.shutdown(reasonAbort, exitCode(err, 1), msg)
 }
 
-// ShutdownOn causes Shutdown to be called when the current process receives
-// one of the given signals.
-func ShutdownOn(sigs ...os.Signal) {
-	signals.RegisterHandler(func(os.Signal) bool { Shutdown(); return true }, sigs...)
+// ShutdownOn causes a.Shutdown to be called when the current process
+// receives one of the given signals.
+func (a *App) ShutdownOn(sigs ...os.Signal) {
+	signals.RegisterHandler(func(sig os.Signal) bool { a.handleShutdownSignal(sig); return true }, sigs...)
 }
 
-func shutdown(code int, mesg string) {
-	downmutex.Lock()
-	defer downmutex.Unlock()
-	if finalized {
+// signalShutdown is called upon receipt of a shutdown signal. If a's
+// lifecycle is being managed by Run, this merely cancels the Context passed
+// to its main function; otherwise, a is shut down immediately.
+func (a *App) signalShutdown() {
+	if a.cancelRun() {
 		return
 	}
-	finalized = true
-
-	// accumulate time allowances for all shutdown actions
-	var ta time.Duration
-	for _, da := range downactions {
-		// TODO(tep): This should only sum allowances for applicable actions
-		//            (using da.onShutdown and da.OnAbort)
-		ta += da.allowance
-	}
-	ta += ta / 5 // fudge by an additional 20%
-
-	done := make(chan struct{})
-
-	// Call each shutdown action in reverse registration order.
-	// Do this in a goroutine which closes the 'done' channel
-	// at the end of the loop.
-	go func() {
-		defer close(done)
-		for i := len(downactions) - 1; i >= 0; i-- {
-			if log.V(1) {
-				log.Infof("calling shutdown func: %v", runtimeutil.FuncID(downactions[i].downFunc))
-			}
-			downactions[i].downFunc()
+	a.shutdown(reasonSignal, 0, "")
+}
+
+func (a *App) shutdown(why downReason, code int, mesg string) {
+	a.downmutex.Lock()
+	defer a.downmutex.Unlock()
+	if a.finalized {
+		return
+	}
+	a.finalized = true
+
+	// let everyone know that shutdown has begun
+	a.cancelCtx()
+
+	if a.sdNotify {
+		a.sdStopping()
+	}
+
+	report := &ShutdownReport{
+		Reason:   why.String(),
+		ExitCode: code,
+		Start:    time.Now(),
+	}
+
+	// select the shutdown actions applicable for this termination, in
+	// reverse registration order, then (stably) order them by phase.
+	var actions, skipped []*shutdownAction
+	for i := len(a.downactions) - 1; i >= 0; i-- {
+		if da := a.downactions[i]; da.appliesTo(why) {
+			actions = append(actions, da)
+		} else {
+			skipped = append(skipped, da)
 		}
-	}()
+	}
 
-	tout := time.After(ta)
+	sort.SliceStable(actions, func(i, j int) bool { return actions[i].phase < actions[j].phase })
 
-	// wait for either the goroutine to complete or the timer to expire,
-	// whichever comes first.
-	select {
-	case <-done:
-	case <-tout:
+	// Execute each phase in turn; runPhase doesn't return until all of the
+	// phase's actions have either completed or been abandoned.
+	for len(actions) > 0 {
+		n := 1
+		for n < len(actions) && actions[n].phase == actions[0].phase {
+			n++
+		}
+		report.Actions = append(report.Actions, runPhase(actions[:n])...)
+		actions = actions[n:]
+	}
+
+	for _, da := range skipped {
+		report.Actions = append(report.Actions, &ActionReport{ID: da.id, Phase: da.phase, Skipped: true})
+	}
+
+	report.Duration = time.Since(report.Start)
+
+	// A panicking ShutdownFunc turns an otherwise clean exit into a failure.
+	if code == 0 && report.panicked() {
+		code = 1
+		report.ExitCode = code
+	}
+
+	report.log()
+
+	log.Flush()
+
+	if a.reportFile != "" {
+		if err := report.writeFile(a.reportFile); err != nil {
+			fmt.Fprintf(a.stderr, "Failed to write shutdown report: %v\n", err)
+		}
 	}
 
 	if mesg != "" {
-		fmt.Fprintln(os.Stderr, mesg)
+		fmt.Fprintln(a.stderr, mesg)
+	}
+
+	if a.exitFunc != nil {
+		a.exitFunc(code)
+	}
+}
+
+// runPhase executes the given shutdown actions, all of which belong to the
+// same phase. Actions registered as Concurrent are all started immediately,
+// each in its own goroutine, while the others are executed serially in the
+// order given. Each action is bounded by its own time allowance. An
+// ActionReport is returned for each action, in the order given.
+func runPhase(actions []*shutdownAction) []*ActionReport {
+	var (
+		reports = make([]*ActionReport, len(actions))
+		wg      sync.WaitGroup
+	)
+
+	for i, da := range actions {
+		if da.concurrent {
+			wg.Add(1)
+			go func(i int, da *shutdownAction) {
+				defer wg.Done()
+				reports[i] = da.run()
+			}(i, da)
+		}
+	}
+
+	for i, da := range actions {
+		if !da.concurrent {
+			reports[i] = da.run()
+		}
+	}
+
+	wg.Wait()
+
+	return reports
+}
+
+// run calls the shutdown action, logs its outcome and returns an
+// ActionReport describing it.
+func (sa *shutdownAction) run() *ActionReport {
+	if log.V(1) {
+		log.Infof("calling shutdown func: %v", sa.id)
+	}
+
+	ar := &ActionReport{ID: sa.id, Phase: sa.phase}
+
+	start := time.Now()
+	err := sa.call(context.Background())
+	ar.Duration = time.Since(start)
+
+	switch {
+	case err == errAbandoned:
+		log.Warningf("shutdown func %s abandoned after exceeding its %v allowance", sa.id, sa.allowance)
+		ar.TimedOut = true
+
+	case err != nil:
+		if pe, ok := err.(*PanicError); ok {
+			log.Errorf("shutdown func %s panicked: %v\n%s", sa.id, pe.Value, pe.Stack)
+			ar.Panic = fmt.Sprint(pe.Value)
+		} else {
+			log.Errorf("shutdown func %s failed: %v", sa.id, err)
+		}
+		ar.Error = err.Error()
+	}
+
+	return ar
+}
+
+func (sa *shutdownAction) appliesTo(why downReason) bool {
+	switch why {
+	case reasonAbort:
+		return sa.onAbort
+	case reasonSignal:
+		return sa.onShutdown || sa.onSignal
+	default:
+		return sa.onShutdown
 	}
+}
+
+var errAbandoned = errors.New("time allowance exceeded")
+
+// call executes the action's ShutdownCtxFunc, waiting no longer than its
+// time allowance for it to complete. If the allowance is exceeded, the
+// function is left running in the background, its Context is cancelled and
+// errAbandoned is returned. A panic in the function is returned as a
+// *PanicError.
+func (sa *shutdownAction) call(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, sa.allowance)
+	defer cancel()
 
-	os.Exit(code)
+	done := make(chan error, 1)
+	go func() { done <- callSafely(func() error { return sa.downFunc(ctx) }) }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return errAbandoned
+	}
 }
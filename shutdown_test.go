@@ -0,0 +1,320 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package toolman
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const envShutdownChild = "TOOLMAN_TEST_SHUTDOWN_CHILD"
+
+// recordPrefix marks the lines written by ShutdownFuncs created by record.
+const recordPrefix = "shutdown func: "
+
+// record returns a ShutdownFunc that reports its execution, by name, on
+// STDOUT for the parent test to collect (see runShutdownChild).
+func record(name string) func() {
+	return func() { fmt.Println(recordPrefix + name) }
+}
+
+// inShutdownChild reports whether the current test is being executed as a
+// child process by runShutdownChild. A test running as a child should
+// register its ShutdownFuncs and then terminate via Shutdown or Abort.
+func inShutdownChild(t *testing.T) bool {
+	return os.Getenv(envShutdownChild) == t.Name()
+}
+
+// runShutdownChild re-executes the test binary to run only the current test
+// in a child process, since Shutdown and Abort terminate the process. It
+// returns the names recorded by the child's ShutdownFuncs, in the order they
+// were executed, along with the child's exit code.
+func runShutdownChild(t *testing.T) ([]string, int) {
+	t.Helper()
+
+	var pattern []string
+	for _, name := range strings.Split(t.Name(), "/") {
+		pattern = append(pattern, "^"+regexp.QuoteMeta(name)+"$")
+	}
+
+	var out bytes.Buffer
+
+	cmd := exec.Command(os.Args[0], "-test.run="+strings.Join(pattern, "/"))
+	cmd.Env = append(os.Environ(), envShutdownChild+"="+t.Name())
+	cmd.Stdout = &out
+
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Don't let a hung shutdown hang the test as well
+	timer := time.AfterFunc(30*time.Second, func() { cmd.Process.Kill() })
+	defer timer.Stop()
+
+	var code int
+	if err := cmd.Wait(); err != nil {
+		ee, ok := err.(*exec.ExitError)
+		if !ok {
+			t.Fatal(err)
+		}
+		code = ee.ExitCode()
+	}
+
+	var order []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.HasPrefix(line, recordPrefix) {
+			order = append(order, strings.TrimPrefix(line, recordPrefix))
+		}
+	}
+
+	return order, code
+}
+
+func TestShutdownOrder(t *testing.T) {
+	type reg struct {
+		name string
+		opts []ShutdownOption
+	}
+
+	abort := func() { Abort(errors.New("test")) }
+
+	cases := []struct {
+		name string
+		regs []reg
+		down func()
+		want []string
+		code int
+	}{
+		{
+			name: "reverse-registration",
+			regs: []reg{{name: "a"}, {name: "b"}, {name: "c"}},
+			down: Shutdown,
+			want: []string{"c", "b", "a"},
+		},
+		{
+			name: "phases",
+			regs: []reg{
+				{"a", []ShutdownOption{Phase(Release)}},
+				{"b", []ShutdownOption{Phase(StopAccepting)}},
+				{"c", nil},
+				{"d", []ShutdownOption{Phase(Drain)}},
+				{"e", []ShutdownOption{Phase(StopAccepting)}},
+				{"f", []ShutdownOption{Phase(Flush)}},
+			},
+			down: Shutdown,
+			want: []string{"e", "b", "d", "c", "f", "a"},
+		},
+		{
+			name: "interleaved-phase",
+			regs: []reg{
+				{"a", []ShutdownOption{Phase(Cleanup + 1)}},
+				{"b", []ShutdownOption{Phase(Flush)}},
+				{"c", nil},
+			},
+			down: Shutdown,
+			want: []string{"c", "a", "b"},
+		},
+		{
+			name: "on-shutdown",
+			regs: []reg{
+				{"a", []ShutdownOption{OnShutdown()}},
+				{"b", []ShutdownOption{OnAbort()}},
+				{"c", nil},
+			},
+			down: Shutdown,
+			want: []string{"c", "a"},
+		},
+		{
+			name: "on-abort",
+			regs: []reg{
+				{"a", []ShutdownOption{OnShutdown()}},
+				{"b", []ShutdownOption{OnAbort()}},
+				{"c", nil},
+			},
+			down: abort,
+			want: []string{"c", "b"},
+			code: 1,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if inShutdownChild(t) {
+				for _, reg := range tc.regs {
+					RegisterShutdown(record(reg.name), reg.opts...)
+				}
+				tc.down()
+				t.Fatal("child did not exit")
+			}
+
+			order, code := runShutdownChild(t)
+
+			if !reflect.DeepEqual(order, tc.want) {
+				t.Errorf("ShutdownFuncs executed in order %v; wanted %v", order, tc.want)
+			}
+
+			if code != tc.code {
+				t.Errorf("child exited with status %d; wanted %d", code, tc.code)
+			}
+		})
+	}
+}
+
+func TestShutdownConcurrent(t *testing.T) {
+	const n = 3
+
+	if inShutdownChild(t) {
+		var started sync.WaitGroup
+		started.Add(n)
+
+		RegisterShutdown(record("after"), Phase(Cleanup))
+
+		// Each of these blocks until all have been started; executed serially,
+		// they would exceed their time allowance.
+		for i := 0; i < n; i++ {
+			RegisterShutdown(func() {
+				started.Done()
+				started.Wait()
+				record("concurrent")()
+			}, Phase(Drain), Concurrent(), TimeAllowance(5*time.Second))
+		}
+
+		RegisterShutdown(record("serial"), Phase(Drain))
+
+		Shutdown()
+		t.Fatal("child did not exit")
+	}
+
+	start := time.Now()
+
+	order, code := runShutdownChild(t)
+
+	if d := time.Since(start); d > 4*time.Second {
+		t.Errorf("concurrent ShutdownFuncs took %v", d)
+	}
+
+	if len(order) != n+2 {
+		t.Fatalf("ShutdownFuncs executed %v; wanted %d", order, n+2)
+	}
+
+	if got := order[len(order)-1]; got != "after" {
+		t.Errorf("ShutdownFuncs executed in order %v; wanted %q last", order, "after")
+	}
+
+	if code != 0 {
+		t.Errorf("child exited with status %d; wanted 0", code)
+	}
+}
+
+func TestShutdownAbandoned(t *testing.T) {
+	cases := []struct {
+		name string
+		reg  func(opts ...ShutdownOption)
+	}{
+		{
+			name: "blocking",
+			reg: func(opts ...ShutdownOption) {
+				RegisterShutdown(func() { select {} }, opts...)
+			},
+		},
+		{
+			name: "context",
+			reg: func(opts ...ShutdownOption) {
+				RegisterShutdownCtx(func(ctx context.Context) error {
+					<-ctx.Done()
+					return ctx.Err()
+				}, opts...)
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if inShutdownChild(t) {
+				RegisterShutdown(record("after"))
+				tc.reg(TimeAllowance(20 * time.Millisecond))
+				RegisterShutdown(record("before"))
+				Shutdown()
+				t.Fatal("child did not exit")
+			}
+
+			start := time.Now()
+
+			order, code := runShutdownChild(t)
+
+			if d := time.Since(start); d > 5*time.Second {
+				t.Errorf("shutdown took %v", d)
+			}
+
+			if want := []string{"before", "after"}; !reflect.DeepEqual(order, want) {
+				t.Errorf("ShutdownFuncs executed in order %v; wanted %v", order, want)
+			}
+
+			if code != 0 {
+				t.Errorf("child exited with status %d; wanted 0", code)
+			}
+		})
+	}
+}
+
+func TestShutdownPanic(t *testing.T) {
+	if inShutdownChild(t) {
+		RegisterShutdown(record("after"))
+		RegisterShutdown(func() { panic("boom") })
+		Shutdown()
+		t.Fatal("child did not exit")
+	}
+
+	order, code := runShutdownChild(t)
+
+	if want := []string{"after"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("ShutdownFuncs executed in order %v; wanted %v", order, want)
+	}
+
+	if code != 1 {
+		t.Errorf("child exited with status %d; wanted 1", code)
+	}
+}
+
+func TestTimeAllowanceNonPositive(t *testing.T) {
+	a := NewApp(t.Name())
+	a.RegisterShutdown(func() {}, TimeAllowance(0))
+	a.RegisterShutdown(func() {}, TimeAllowance(-time.Second))
+	a.RegisterShutdown(func() {}, TimeAllowance(time.Second))
+
+	want := []time.Duration{100 * time.Millisecond, 100 * time.Millisecond, time.Second}
+
+	for i, sa := range a.downactions {
+		if sa.allowance != want[i] {
+			t.Errorf("ShutdownFunc #%d has allowance %v; wanted %v", i, sa.allowance, want[i])
+		}
+	}
+}
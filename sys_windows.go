@@ -0,0 +1,56 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package toolman
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// DebugSignals is not supported on Windows.
+var goroutineDumpSig, heapDumpSig os.Signal
+
+// errWouldBlock is never returned by tryLock on Windows.
+var errWouldBlock = errors.New("file is locked")
+
+// tryLock is a no-op on Windows; files are not locked.
+func tryLock(f *os.File) error {
+	return nil
+}
+
+// processExists always returns false on Windows.
+func processExists(pid int) bool {
+	return false
+}
+
+// closeOnExec is a no-op on Windows, where descriptors are not inherited.
+func closeOnExec(fd int) {}
+
+// detachedProcAttr returns nil on Windows; processes are not detached.
+func detachedProcAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+// dupFD is not supported on Windows.
+func dupFD(oldfd, newfd int) error {
+	return errors.New("not supported on windows")
+}
@@ -0,0 +1,156 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package toolman
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"toolman.org/base/log/v2"
+)
+
+// SetStatus sends a free-form status string describing the program's state
+// to systemd (i.e. "STATUS=..."). If the program is not running under systemd
+// with a notification socket (i.e. NOTIFY_SOCKET is unset), SetStatus does
+// nothing. See also the SystemdNotify InitOption.
+func SetStatus(status string) error {
+	return sdNotify("STATUS=" + status)
+}
+
+// sdNotify sends state to the systemd notification socket named by the
+// NOTIFY_SOCKET environment variable. If NOTIFY_SOCKET is unset, sdNotify
+// does nothing.
+func sdNotify(state string) error {
+	name := os.Getenv("NOTIFY_SOCKET")
+	if name == "" {
+		return nil
+	}
+
+	// A leading '@' denotes a socket in the abstract namespace
+	if strings.HasPrefix(name, "@") {
+		name = "\x00" + name[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: name, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// sdWatchdogInterval returns the interval at which watchdog keep-alive
+// notifications should be sent to systemd, or zero if the watchdog is not
+// enabled for this process.
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	if p := os.Getenv("WATCHDOG_PID"); p != "" && p != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	// ping at half the configured timeout
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// sdReady notifies systemd that a has completed its initialization and, if
+// systemd's watchdog is enabled, starts sending periodic keep-alive
+// notifications until a begins to shut down.
+func (a *App) sdReady() {
+	if err := sdNotify("READY=1"); err != nil {
+		log.Errorf("systemd notify READY: %v", err)
+	}
+
+	iv := sdWatchdogInterval()
+	if iv == 0 {
+		return
+	}
+
+	log.V(1).Infof("sending systemd watchdog notifications every %v", iv)
+
+	go func() {
+		t := time.NewTicker(iv)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-t.C:
+				if err := sdNotify("WATCHDOG=1"); err != nil {
+					log.Errorf("systemd notify WATCHDOG: %v", err)
+				}
+			case <-a.ShuttingDown():
+				return
+			}
+		}
+	}()
+}
+
+// sdStopping notifies systemd that a has begun to shut down.
+func (a *App) sdStopping() {
+	if err := sdNotify("STOPPING=1"); err != nil {
+		log.Errorf("systemd notify STOPPING: %v", err)
+	}
+}
+
+// sdHandOff tells systemd that the process with the given pid, started by
+// Restart, is now the service's main process. Since the service itself is not
+// stopping, a no longer sends STOPPING=1 when shutting down.
+func (a *App) sdHandOff(pid int) {
+	a.downmutex.Lock()
+	defer a.downmutex.Unlock()
+
+	if !a.sdNotify {
+		return
+	}
+	a.sdNotify = false
+
+	if err := sdNotify("MAINPID=" + strconv.Itoa(pid)); err != nil {
+		log.Errorf("systemd notify MAINPID: %v", err)
+	}
+}
+
+// sdTakeOver is called once a has taken over from its parent process ppid as
+// the service's main process (see Restart). A watchdog enabled for our
+// parent now applies to us and, as with any other process, a then notifies
+// systemd that it is ready.
+func (a *App) sdTakeOver(ppid int) {
+	if os.Getenv("WATCHDOG_PID") == strconv.Itoa(ppid) {
+		os.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	}
+
+	a.downmutex.Lock()
+	defer a.downmutex.Unlock()
+
+	if a.finalized {
+		return
+	}
+	a.sdNotify = true
+
+	a.sdReady()
+}
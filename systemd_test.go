@@ -0,0 +1,147 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+//go:build !windows
+// +build !windows
+
+package toolman
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// notifySocket stands in for systemd's notification socket.
+type notifySocket struct {
+	*net.UnixConn
+	t *testing.T
+}
+
+func newNotifySocket(t *testing.T, env map[string]string) (*notifySocket, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "toolman-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	env["NOTIFY_SOCKET"] = path
+	for k, v := range env {
+		os.Setenv(k, v)
+	}
+
+	return &notifySocket{conn, t}, func() {
+		for k := range env {
+			os.Unsetenv(k)
+		}
+		conn.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// next returns the next notification received within timeout, or the empty
+// string if none arrives.
+func (ns *notifySocket) next(timeout time.Duration) string {
+	ns.SetReadDeadline(time.Now().Add(timeout))
+
+	buf := make([]byte, 1024)
+	n, err := ns.Read(buf)
+	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return ""
+		}
+		ns.t.Fatal(err)
+	}
+
+	return string(buf[:n])
+}
+
+func (ns *notifySocket) expect(want string) {
+	ns.t.Helper()
+	if got := ns.next(5 * time.Second); got != want {
+		ns.t.Fatalf("received notification %q; wanted %q", got, want)
+	}
+}
+
+func TestSetStatus(t *testing.T) {
+	ns, cleanup := newNotifySocket(t, map[string]string{})
+	defer cleanup()
+
+	if err := SetStatus("testing"); err != nil {
+		t.Fatalf("SetStatus() failed: %v", err)
+	}
+
+	ns.expect("STATUS=testing")
+}
+
+func TestSystemdNotify(t *testing.T) {
+	ns, cleanup := newNotifySocket(t, map[string]string{"WATCHDOG_USEC": "20000"})
+	defer cleanup()
+
+	defer func(a *App) { std = a }(std)
+	std = newStdApp()
+	std.setExit(func(int) {}, ioutil.Discard)
+
+	Init(CommandArgs(), Quiet(), SystemdNotify())
+
+	ns.expect("READY=1")
+	ns.expect("WATCHDOG=1")
+
+	Shutdown()
+
+	// Skip any watchdog notifications sent before shutdown began
+	for {
+		got := ns.next(5 * time.Second)
+		if got == "STOPPING=1" {
+			break
+		}
+		if got != "WATCHDOG=1" {
+			t.Fatalf("received notification %q; wanted %q", got, "STOPPING=1")
+		}
+	}
+
+	if got := ns.next(50 * time.Millisecond); got != "" {
+		t.Errorf("received notification %q after shutdown", got)
+	}
+}
+
+func TestSystemdNotifyNewApp(t *testing.T) {
+	ns, cleanup := newNotifySocket(t, map[string]string{"WATCHDOG_USEC": "20000"})
+	defer cleanup()
+
+	a := NewApp(t.Name())
+	a.Init(CommandArgs(), SystemdNotify())
+	a.Shutdown()
+
+	if got := ns.next(100 * time.Millisecond); got != "" {
+		t.Errorf("App from NewApp sent notification %q", got)
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+// Package toolmantest provides utilities for testing code that registers
+// InitFuncs and ShutdownFuncs with package toolman, or otherwise exercises
+// the toolman lifecycle, from within a normal "go test" run.
+//
+// A typical test looks something like:
+//
+//	func TestShutdown(t *testing.T) {
+//		toolmantest.Reset()
+//		defer toolmantest.Reset()
+//
+//		mylib.Register()
+//
+//		res := toolmantest.Capture(func() {
+//			toolman.Init(toolman.Quiet())
+//			toolman.Abort(errors.New("boom"))
+//		})
+//
+//		if !res.Exited || res.Code != 1 || res.Message != "boom" {
+//			t.Errorf("unexpected result: %+v", res)
+//		}
+//	}
+//
+// Note that Reset only restores toolman's own state; flags defined on the
+// global FlagSet and signal handlers installed by Init remain in place.
+package toolmantest
+
+import (
+	"bytes"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+
+	"toolman.org/base/toolman/v2"
+	"toolman.org/base/toolman/v2/internal/hooks"
+)
+
+// Ensure package toolman is initialized (and its hooks installed) even if
+// the test itself doesn't reference it directly.
+var _ = toolman.CommandName
+
+var (
+	mu sync.Mutex
+
+	snapOnce sync.Once
+	restore  func()
+)
+
+// Reset restores toolman's package state to that of a program that has not
+// yet called Init, which allows Init to be called again.
+//
+// The InitFuncs, ShutdownFuncs and ReloadFuncs registered when Reset is
+// first called are retained; all others are discarded. Since tests only run
+// once all packages have been initialized, this preserves the registrations
+// made by init functions in the packages under test. Tests should therefore
+// call Reset before registering anything themselves.
+func Reset() {
+	snapOnce.Do(func() { restore = hooks.Snapshot() })
+	restore()
+}
+
+// Result describes the termination of a toolman lifecycle observed by
+// Capture.
+type Result struct {
+	// Exited is true if Shutdown or Abort (or similar) attempted to
+	// terminate the process.
+	Exited bool
+
+	// Code is the exit code that would have been passed to os.Exit.
+	Code int
+
+	// Message holds anything that would have been written to STDERR at
+	// termination, such as the error passed to Abort.
+	Message string
+}
+
+// Capture calls f in a new goroutine with toolman's process exit replaced
+// by a stub that records the exit code and message. Capture returns once f
+// returns or the toolman lifecycle attempts to exit, whichever happens first.
+//
+// When an exit is captured, the goroutine that initiated it is terminated
+// (via runtime.Goexit) just as it would have been by os.Exit; any deferred
+// calls in that goroutine will be run.
+func Capture(f func()) *Result {
+	mu.Lock()
+	defer mu.Unlock()
+
+	var buf bytes.Buffer
+
+	exited := make(chan int, 1)
+	returned := make(chan struct{})
+
+	hooks.SetExit(func(code int) {
+		select {
+		case exited <- code:
+		default:
+		}
+		runtime.Goexit()
+	}, &buf)
+	defer hooks.SetExit(os.Exit, os.Stderr)
+
+	go func() {
+		defer close(returned)
+		f()
+	}()
+
+	res := &Result{}
+
+	select {
+	case res.Code = <-exited:
+		res.Exited = true
+	case <-returned:
+		select {
+		case res.Code = <-exited:
+			res.Exited = true
+		default:
+		}
+	}
+
+	res.Message = strings.TrimSuffix(buf.String(), "\n")
+
+	return res
+}
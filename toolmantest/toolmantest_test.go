@@ -0,0 +1,324 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package toolmantest_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"toolman.org/base/toolman/v2"
+	"toolman.org/base/toolman/v2/toolmantest"
+)
+
+// initArgs are the InitOptions used by each test; CommandArgs keeps Init
+// from parsing the test binary's own flags.
+func initArgs(opts ...*toolman.InitOption) []*toolman.InitOption {
+	return append([]*toolman.InitOption{toolman.CommandArgs(), toolman.Quiet()}, opts...)
+}
+
+func TestCapture(t *testing.T) {
+	cases := []struct {
+		name string
+		term func()
+		want toolmantest.Result
+	}{
+		{
+			name: "shutdown",
+			term: toolman.Shutdown,
+			want: toolmantest.Result{Exited: true, Code: 0},
+		},
+		{
+			name: "abort",
+			term: func() { toolman.Abort(errors.New("boom")) },
+			want: toolmantest.Result{Exited: true, Code: 1, Message: "boom"},
+		},
+		{
+			name: "abort-nil",
+			term: func() { toolman.Abort(nil) },
+			want: toolmantest.Result{Exited: true, Code: 1},
+		},
+		{
+			name: "abort-exit-error",
+			term: func() { toolman.Abort(toolman.NewExitError(toolman.EX_CONFIG, "bad config")) },
+			want: toolmantest.Result{Exited: true, Code: toolman.EX_CONFIG, Message: "bad config"},
+		},
+		{
+			name: "exit",
+			term: func() { toolman.Exit(toolman.EX_USAGE, errors.New("usage")) },
+			want: toolmantest.Result{Exited: true, Code: toolman.EX_USAGE, Message: "usage"},
+		},
+		{
+			name: "no-exit",
+			term: func() {},
+			want: toolmantest.Result{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			toolmantest.Reset()
+			defer toolmantest.Reset()
+
+			got := toolmantest.Capture(func() {
+				toolman.Init(initArgs()...)
+				tc.term()
+			})
+
+			if *got != tc.want {
+				t.Errorf("Capture(%s) == %+v; wanted %+v", tc.name, *got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCaptureGoexit(t *testing.T) {
+	toolmantest.Reset()
+	defer toolmantest.Reset()
+
+	var after bool
+	deferred := make(chan struct{})
+
+	res := toolmantest.Capture(func() {
+		defer close(deferred)
+		toolman.Init(initArgs()...)
+		toolman.Abort(errors.New("boom"))
+		after = true
+	})
+
+	if !res.Exited || res.Code != 1 {
+		t.Errorf("Capture() == %+v; wanted exit with code 1", res)
+	}
+
+	select {
+	case <-deferred:
+	case <-time.After(5 * time.Second):
+		t.Fatal("deferred call not executed after captured exit")
+	}
+
+	if after {
+		t.Error("execution continued after captured exit")
+	}
+}
+
+func TestCaptureCatchPanics(t *testing.T) {
+	toolmantest.Reset()
+	defer toolmantest.Reset()
+
+	var ran bool
+	toolman.RegisterShutdown(func() { ran = true }, toolman.OnAbort())
+
+	res := toolmantest.Capture(func() {
+		toolman.Init(initArgs(toolman.CatchPanics())...)
+		defer toolman.Shutdown()
+		panic("oops")
+	})
+
+	if !res.Exited || res.Code != 1 {
+		t.Errorf("Capture() == %+v; wanted exit with code 1", res)
+	}
+
+	if !strings.HasPrefix(res.Message, "panic: oops") {
+		t.Errorf("Capture().Message == %q; wanted prefix %q", res.Message, "panic: oops")
+	}
+
+	if !ran {
+		t.Error("OnAbort ShutdownFunc not executed")
+	}
+}
+
+func TestCaptureInitFailure(t *testing.T) {
+	toolmantest.Reset()
+	defer toolmantest.Reset()
+
+	var flushed bool
+	toolman.RegisterInit(func() { panic("buggy library") })
+	toolman.RegisterShutdown(func() { flushed = true })
+
+	res := toolmantest.Capture(func() {
+		toolman.Init(initArgs()...)
+		t.Error("Init returned after a failing InitFunc")
+	})
+
+	if !res.Exited || res.Code != 1 || !strings.Contains(res.Message, "buggy library") {
+		t.Errorf("Capture() == %+v; wanted exit with code 1 mentioning the panic", res)
+	}
+
+	if !flushed {
+		t.Error("ShutdownFunc not executed after failing InitFunc")
+	}
+}
+
+func TestCaptureInitExitError(t *testing.T) {
+	toolmantest.Reset()
+	defer toolmantest.Reset()
+
+	toolman.RegisterInitE(func() error { return errors.New("also failed") })
+	toolman.RegisterInitE(func() error { return toolman.NewExitError(toolman.EX_CONFIG, "bad config") })
+
+	res := toolmantest.Capture(func() {
+		toolman.Init(initArgs()...)
+		t.Error("Init returned after a failing InitFunc")
+	})
+
+	if !res.Exited || res.Code != toolman.EX_CONFIG || !strings.Contains(res.Message, "bad config") {
+		t.Errorf("Capture() == %+v; wanted exit with code %d mentioning the error", res, toolman.EX_CONFIG)
+	}
+}
+
+func TestReset(t *testing.T) {
+	toolmantest.Reset()
+	defer toolmantest.Reset()
+
+	var inits, downs int
+	toolman.RegisterInit(func() { inits++ })
+	toolman.RegisterShutdown(func() { downs++ })
+
+	toolmantest.Capture(func() {
+		toolman.Init(initArgs()...)
+		toolman.Shutdown()
+	})
+
+	if inits != 1 || downs != 1 {
+		t.Fatalf("got %d inits and %d shutdowns; wanted 1 of each", inits, downs)
+	}
+
+	toolmantest.Reset()
+
+	// Init may be called again and previous registrations are discarded.
+	res := toolmantest.Capture(func() {
+		toolman.Init(initArgs()...)
+		toolman.Shutdown()
+	})
+
+	if !res.Exited || res.Code != 0 {
+		t.Errorf("Capture() after Reset == %+v; wanted exit with code 0", res)
+	}
+
+	if inits != 1 || downs != 1 {
+		t.Errorf("got %d inits and %d shutdowns after Reset; wanted 1 of each", inits, downs)
+	}
+}
+
+// libInits counts executions of an InitFunc registered, as a library
+// would, from an init function.
+var libInits int
+
+func init() {
+	toolman.RegisterInit(func() { libInits++ })
+}
+
+func TestResetKeepsInitRegistrations(t *testing.T) {
+	toolmantest.Reset()
+	defer toolmantest.Reset()
+
+	for i := 1; i <= 2; i++ {
+		libInits = 0
+
+		toolmantest.Capture(func() {
+			toolman.Init(initArgs()...)
+			toolman.Shutdown()
+		})
+
+		if libInits != 1 {
+			t.Errorf("pass %d: InitFunc registered by init() executed %d times; wanted 1", i, libInits)
+		}
+
+		toolmantest.Reset()
+	}
+}
+
+func TestCaptureForwarded(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("SingleInstance is not supported on windows")
+	}
+
+	dir, err := ioutil.TempDir("", "toolmantest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old, had := os.LookupEnv("XDG_RUNTIME_DIR")
+	os.Setenv("XDG_RUNTIME_DIR", dir)
+	defer func() {
+		if had {
+			os.Setenv("XDG_RUNTIME_DIR", old)
+		} else {
+			os.Unsetenv("XDG_RUNTIME_DIR")
+		}
+	}()
+
+	running := toolman.NewApp("running")
+	if err := running.InitE(toolman.CommandArgs(), toolman.SingleInstance(t.Name()), toolman.ForwardArgs(func([]string) {})); err != nil {
+		t.Fatalf("InitE() failed: %v", err)
+	}
+	defer running.Shutdown()
+
+	opts := initArgs(toolman.SingleInstance(t.Name()), toolman.ForwardArgs(func([]string) {}))
+
+	cases := []struct {
+		name string
+		f    func()
+	}{
+		{
+			name: "init",
+			f: func() {
+				toolman.Init(opts...)
+				t.Error("Init returned after forwarding arguments")
+			},
+		},
+		{
+			name: "run",
+			f: func() {
+				toolman.Run(func(context.Context) error {
+					t.Error("main called after forwarding arguments")
+					return nil
+				}, opts...)
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			toolmantest.Reset()
+			defer toolmantest.Reset()
+
+			var aborted bool
+			toolman.RegisterShutdown(func() { aborted = true }, toolman.OnAbort())
+
+			res := toolmantest.Capture(tc.f)
+
+			if want := (toolmantest.Result{Exited: true}); *res != want {
+				t.Errorf("Capture() == %+v; wanted %+v", *res, want)
+			}
+
+			if aborted {
+				t.Error("OnAbort ShutdownFunc executed after forwarding arguments")
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package toolman
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/spf13/pflag"
+
+	"toolman.org/base/log/v2"
+	"toolman.org/base/signals"
+)
+
+const verbosityFlag = "v"
+
+var verbositymutex sync.Mutex
+
+// Verbosity returns the current log verbosity level (i.e. the value of the
+// --v flag) used by log.V.
+func Verbosity() int {
+	v, _ := flagIsSet(verbosityFlag)
+	n, _ := strconv.Atoi(v)
+	return n
+}
+
+// SetVerbosity changes the log verbosity level used by log.V to n, allowing
+// verbose logging to be enabled or disabled at runtime. The change is logged.
+func SetVerbosity(n int) error {
+	verbositymutex.Lock()
+	defer verbositymutex.Unlock()
+
+	return setVerbosity(n)
+}
+
+func setVerbosity(n int) error {
+	old := Verbosity()
+
+	if err := pflag.Set(verbosityFlag, strconv.Itoa(n)); err != nil {
+		return fmt.Errorf("setting log verbosity to %d: %v", n, err)
+	}
+
+	log.Infof("log verbosity changed from %d to %d", old, n)
+
+	return nil
+}
+
+// setupVerbositySignal installs a handler that cycles the log verbosity level
+// from 0 through max (and back to 0) on each receipt of sig.
+func setupVerbositySignal(sig os.Signal, max int) {
+	signals.RegisterHandler(func(os.Signal) bool {
+		verbositymutex.Lock()
+		defer verbositymutex.Unlock()
+
+		n := Verbosity() + 1
+		if n > max {
+			n = 0
+		}
+
+		if err := setVerbosity(n); err != nil {
+			log.Error(err)
+		}
+
+		return true
+	}, sig)
+}
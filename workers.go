@@ -0,0 +1,90 @@
+// Copyright 2017, 2018, 2019 Timothy E. Peoples
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
+// FROM, OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS
+// IN THE SOFTWARE.
+
+package toolman
+
+import (
+	"context"
+	"time"
+)
+
+// Context returns a Context that is cancelled as soon as the program begins
+// to shut down (via Shutdown, Abort, a shutdown signal, etc.) and before any
+// ShutdownFuncs are executed. Long-running goroutines should watch this
+// Context (or the channel returned by ShuttingDown) and stop cleanly once it
+// is done. See WaitFor for having shutdown wait on these goroutines.
+func Context() context.Context {
+	return std.Context()
+}
+
+// ShuttingDown returns a channel that is closed when the program begins to
+// shut down. It is equivalent to Context().Done().
+func ShuttingDown() <-chan struct{} {
+	return std.ShuttingDown()
+}
+
+// WaitFor adds n to the number of tracked workers that shutdown will wait
+// for. Each tracked worker must call WorkerDone once it has finished. During
+// shutdown, and after the StopAccepting phase, the program waits for all
+// tracked workers to finish for up to the time allowed by WorkerAllowance
+// (1s by default).
+//
+// As with sync.WaitGroup, calls to WaitFor should be made before starting
+// the worker; they should not be made once shutdown has begun.
+func WaitFor(n int) {
+	std.WaitFor(n)
+}
+
+// WorkerDone indicates that a worker tracked by WaitFor has finished.
+func WorkerDone() {
+	std.WorkerDone()
+}
+
+// Context returns a Context that is cancelled when a begins to shut down.
+func (a *App) Context() context.Context {
+	return a.ctx
+}
+
+// ShuttingDown returns a channel that is closed when a begins to shut down.
+func (a *App) ShuttingDown() <-chan struct{} {
+	return a.ctx.Done()
+}
+
+// WaitFor adds n to the number of workers a's shutdown will wait for; see
+// the package-level WaitFor for details.
+func (a *App) WaitFor(n int) {
+	a.workers.Add(n)
+}
+
+// WorkerDone indicates that a worker tracked by a.WaitFor has finished.
+func (a *App) WorkerDone() {
+	a.workers.Done()
+}
+
+func (a *App) registerWorkerWait(allowance time.Duration) {
+	if allowance <= 0 {
+		return
+	}
+
+	a.registerShutdown("toolman.waitForWorkers", func(context.Context) error {
+		a.workers.Wait()
+		return nil
+	}, []ShutdownOption{Phase(Drain), TimeAllowance(allowance)})
+}